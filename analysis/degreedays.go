@@ -0,0 +1,123 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// DefaultDegreeDayBase is the usual base temperature in degrees Celsius.
+const DefaultDegreeDayBase = 18.0
+
+// MaxWeatherGap is the longest stretch between two weather observations that
+// is still integrated; longer gaps count as missing data.
+const MaxWeatherGap = 3 * 3600
+
+// DegreeDay holds the heating and cooling degree days of a day (Period is
+// 2006-01-02) or a month (2006-01). Coverage is the number of days of
+// weather data the totals were integrated from.
+type DegreeDay struct {
+	Period   string  `json:"period"`
+	HDD      float64 `json:"hdd"`
+	CDD      float64 `json:"cdd"`
+	Coverage float64 `json:"coverage"`
+}
+
+// DegreeDays are the daily and monthly totals for a location.
+type DegreeDays struct {
+	Base    float64     `json:"base"`
+	Daily   []DegreeDay `json:"daily"`
+	Monthly []DegreeDay `json:"monthly"`
+}
+
+// EstimateDegreeDays computes degree days from the weather stored for a
+// location. Days are calendar days in tz.
+func EstimateDegreeDays(k kb.KB, location int64, start, end, base float64, tz *time.Location) *DegreeDays {
+	return ComputeDegreeDays(k.GetWeather(location, start, end), base, tz)
+}
+
+// ComputeDegreeDays integrates how far the outdoor temperature is below
+// (HDD) or above (CDD) base over time, interpolating linearly between
+// observations. Each step between observations counts toward the day its
+// midpoint falls in.
+func ComputeDegreeDays(weather []kb.Weather, base float64, tz *time.Location) *DegreeDays {
+	if tz == nil {
+		tz = time.UTC
+	}
+	obs := make([]kb.Weather, len(weather))
+	copy(obs, weather)
+	sort.Slice(obs, func(i, j int) bool { return obs[i].Timestamp < obs[j].Timestamp })
+
+	days := make(map[string]*DegreeDay)
+	for i := 1; i < len(obs); i++ {
+		a, b := obs[i-1], obs[i]
+		dt := b.Timestamp - a.Timestamp
+		if dt <= 0 || dt > MaxWeatherGap {
+			continue
+		}
+		mid := a.Timestamp + dt/2
+		sec, frac := math.Modf(mid)
+		period := time.Unix(int64(sec), int64(frac*1e9)).In(tz).Format("2006-01-02")
+		day, ok := days[period]
+		if !ok {
+			day = &DegreeDay{Period: period}
+			days[period] = day
+		}
+		heat, cool := stepDegrees(a.Temperature, b.Temperature, base)
+		day.HDD += heat * dt / 86400
+		day.CDD += cool * dt / 86400
+		day.Coverage += dt / 86400
+	}
+
+	dd := &DegreeDays{Base: base, Daily: make([]DegreeDay, 0, len(days)), Monthly: []DegreeDay{}}
+	for _, day := range days {
+		dd.Daily = append(dd.Daily, *day)
+	}
+	sort.Slice(dd.Daily, func(i, j int) bool { return dd.Daily[i].Period < dd.Daily[j].Period })
+	for _, day := range dd.Daily {
+		month := day.Period[:7]
+		if n := len(dd.Monthly); n == 0 || dd.Monthly[n-1].Period != month {
+			dd.Monthly = append(dd.Monthly, DegreeDay{Period: month})
+		}
+		m := &dd.Monthly[len(dd.Monthly)-1]
+		m.HDD += day.HDD
+		m.CDD += day.CDD
+		m.Coverage += day.Coverage
+	}
+	return dd
+}
+
+// stepDegrees returns the mean heating and cooling degrees over a step whose
+// temperature moves linearly from t0 to t1, splitting the step where it
+// crosses base.
+func stepDegrees(t0, t1, base float64) (heat, cool float64) {
+	d0, d1 := t0-base, t1-base
+	if d0 >= 0 && d1 >= 0 {
+		return 0, (d0 + d1) / 2
+	}
+	if d0 <= 0 && d1 <= 0 {
+		return -(d0 + d1) / 2, 0
+	}
+	// the crossing splits the step into two triangles
+	f := d0 / (d0 - d1)
+	if d0 < 0 {
+		return -d0 * f / 2, d1 * (1 - f) / 2
+	}
+	return -d1 * (1 - f) / 2, d0 * f / 2
+}
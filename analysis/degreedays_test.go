@@ -0,0 +1,141 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+func TestStepDegrees(t *testing.T) {
+	tests := []struct {
+		name       string
+		t0, t1     float64
+		heat, cool float64
+	}{
+		{"above", 20, 22, 0, 3},
+		{"below", 10, 14, 6, 0},
+		{"at base", 18, 18, 0, 0},
+		{"down to base", 26, 18, 0, 4},
+		{"up to base", 10, 18, 4, 0},
+		{"crossing up", 14, 22, 1, 1},
+		{"crossing down", 22, 14, 1, 1},
+		{"crossing up early", 16, 24, 0.25, 2.25},
+		{"crossing down late", 24, 16, 0.25, 2.25},
+	}
+	for _, tt := range tests {
+		heat, cool := stepDegrees(tt.t0, tt.t1, 18)
+		if math.Abs(heat-tt.heat) > 1e-12 || math.Abs(cool-tt.cool) > 1e-12 {
+			t.Errorf("%s: stepDegrees(%v, %v, 18) = %v, %v, want %v, %v", tt.name, tt.t0, tt.t1, heat, cool, tt.heat, tt.cool)
+		}
+		// heating less cooling degrees is how far the mean is below base
+		if d := heat - cool - (18 - (tt.t0+tt.t1)/2); math.Abs(d) > 1e-12 {
+			t.Errorf("%s: heat %v less cool %v is off the mean by %v", tt.name, heat, cool, d)
+		}
+	}
+}
+
+// hourly is weather every hour from start at a constant temperature.
+func hourly(start time.Time, hours int, temperature float64) []kb.Weather {
+	weather := make([]kb.Weather, 0, hours)
+	for i := 0; i < hours; i++ {
+		weather = append(weather, kb.Weather{Timestamp: float64(start.Unix() + int64(i)*3600), Temperature: temperature})
+	}
+	return weather
+}
+
+func TestComputeDegreeDaysGaps(t *testing.T) {
+	day := time.Date(2017, 1, 10, 0, 0, 0, 0, time.UTC)
+	ts := float64(day.Unix())
+	tests := []struct {
+		name     string
+		weather  []kb.Weather
+		hdd      float64
+		coverage float64
+	}{
+		{"one step", []kb.Weather{{Timestamp: ts, Temperature: 8}, {Timestamp: ts + 3600, Temperature: 8}}, 10.0 / 24, 1.0 / 24},
+		{"widest gap", []kb.Weather{{Timestamp: ts, Temperature: 8}, {Timestamp: ts + MaxWeatherGap, Temperature: 8}}, 10.0 / 8, 1.0 / 8},
+		{"too wide a gap", []kb.Weather{{Timestamp: ts, Temperature: 8}, {Timestamp: ts + MaxWeatherGap + 1, Temperature: 8}}, 0, 0},
+		{"gap between steps", []kb.Weather{
+			{Timestamp: ts, Temperature: 8}, {Timestamp: ts + 3600, Temperature: 8},
+			{Timestamp: ts + 3600 + MaxWeatherGap + 1, Temperature: 8}, {Timestamp: ts + 7200 + MaxWeatherGap + 1, Temperature: 8},
+		}, 20.0 / 24, 2.0 / 24},
+		{"repeated observation", []kb.Weather{{Timestamp: ts, Temperature: 8}, {Timestamp: ts, Temperature: 8}, {Timestamp: ts + 3600, Temperature: 8}}, 10.0 / 24, 1.0 / 24},
+		{"out of order", []kb.Weather{{Timestamp: ts + 3600, Temperature: 8}, {Timestamp: ts, Temperature: 8}}, 10.0 / 24, 1.0 / 24},
+		{"whole day", hourly(day, 25, 8), 10, 1},
+	}
+	for _, tt := range tests {
+		dd := ComputeDegreeDays(tt.weather, 18, nil)
+		var hdd, cdd, coverage float64
+		for _, d := range dd.Daily {
+			hdd, cdd, coverage = hdd+d.HDD, cdd+d.CDD, coverage+d.Coverage
+		}
+		if math.Abs(hdd-tt.hdd) > 1e-9 || cdd != 0 || math.Abs(coverage-tt.coverage) > 1e-9 {
+			t.Errorf("%s: HDD %v, CDD %v over %v days, want HDD %v over %v days", tt.name, hdd, cdd, coverage, tt.hdd, tt.coverage)
+		}
+	}
+}
+
+func TestComputeDegreeDaysRollup(t *testing.T) {
+	var weather []kb.Weather
+	weather = append(weather, hourly(time.Date(2017, 1, 30, 0, 0, 0, 0, time.UTC), 25, 8)...)
+	weather = append(weather, hourly(time.Date(2017, 1, 31, 1, 0, 0, 0, time.UTC), 24, 28)...)
+	weather = append(weather, hourly(time.Date(2017, 2, 1, 1, 0, 0, 0, time.UTC), 24, 18)...)
+
+	dd := ComputeDegreeDays(weather, 18, nil)
+	wantDaily := []DegreeDay{
+		{"2017-01-30", 10, 0, 1},
+		{"2017-01-31", 2.5 / 24, 232.5 / 24, 1},
+		{"2017-02-01", 0, 5.0 / 24, 1},
+	}
+	wantMonthly := []DegreeDay{
+		{"2017-01", 10 + 2.5/24, 232.5 / 24, 2},
+		{"2017-02", 0, 5.0 / 24, 1},
+	}
+	checkDegreeDays(t, "daily", dd.Daily, wantDaily)
+	checkDegreeDays(t, "monthly", dd.Monthly, wantMonthly)
+	if dd.Base != 18 {
+		t.Errorf("base %v, want 18", dd.Base)
+	}
+
+	// Days are calendar days where the location is. The step ending at
+	// midnight UTC falls on the next day an hour east.
+	east := ComputeDegreeDays(hourly(time.Date(2017, 1, 30, 0, 0, 0, 0, time.UTC), 25, 8), 18, time.FixedZone("UTC+1", 3600))
+	checkDegreeDays(t, "east daily", east.Daily, []DegreeDay{
+		{"2017-01-30", 230.0 / 24, 0, 23.0 / 24},
+		{"2017-01-31", 10.0 / 24, 0, 1.0 / 24},
+	})
+
+	if empty := ComputeDegreeDays(nil, 18, nil); len(empty.Daily) != 0 || len(empty.Monthly) != 0 {
+		t.Errorf("no weather gave %+v", empty)
+	}
+}
+
+func checkDegreeDays(t *testing.T, name string, got, want []DegreeDay) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: %+v, want %+v", name, got, want)
+		return
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Period != w.Period || math.Abs(g.HDD-w.HDD) > 1e-9 || math.Abs(g.CDD-w.CDD) > 1e-9 || math.Abs(g.Coverage-w.Coverage) > 1e-9 {
+			t.Errorf("%s %d: %+v, want %+v", name, i, g, w)
+		}
+	}
+}
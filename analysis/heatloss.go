@@ -0,0 +1,179 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package analysis turns the raw series stored in the KB into building
+// energy metrics.
+package analysis
+
+import (
+	"errors"
+	"math"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// DefaultMinPoints is the fewest cooldown steps a heat-loss fit accepts.
+const DefaultMinPoints = 8
+
+// ErrTooFewPoints means the range had too little nighttime cooldown data.
+var ErrTooFewPoints = errors.New("analysis: not enough nighttime cooldown data")
+
+// HeatLossParams tunes EstimateHeatLoss. Interval is the resampling grid in
+// seconds. Capacitance is the building's thermal capacitance in J/K; when it
+// is zero only the time constant can be estimated.
+type HeatLossParams struct {
+	Interval    float64
+	Capacitance float64
+	MinPoints   int
+}
+
+// HeatLoss is a fit of the lumped RC model
+//
+//	C dTin/dt = -UA (Tin - Tout)
+//
+// over nighttime cooldown periods. Rate is UA/C in 1/s, TimeConstant is
+// C/UA in hours and UA is in W/K. Each estimate comes with the bounds of a
+// 95% confidence interval; an unbounded time constant is +Inf.
+type HeatLoss struct {
+	Points           int
+	Rate             float64
+	RateLow          float64
+	RateHigh         float64
+	TimeConstant     float64
+	TimeConstantLow  float64
+	TimeConstantHigh float64
+	UA               float64
+	UALow            float64
+	UAHigh           float64
+}
+
+// EstimateHeatLoss fits the model to the mean of the given indoor sensors
+// (all of the user's sensors when none are given) and the outdoor
+// temperature of the location.
+func EstimateHeatLoss(k kb.KB, user string, location int64, sensors []string, start, end float64, p HeatLossParams) (*HeatLoss, error) {
+	series, ok := k.GetAlignedSeries(user, location, sensors, start, end, p.Interval, kb.FillLinear)
+	if !ok {
+		return nil, errors.New("analysis: could not align indoor and outdoor series")
+	}
+	return FitHeatLoss(series, p.Capacitance, p.MinPoints)
+}
+
+// FitHeatLoss fits the model to an aligned series. Steps are used when the
+// sun is down at both ends, the house is warmer than outside and the indoor
+// temperature is not rising, i.e. the heating is off.
+func FitHeatLoss(series *kb.AlignedSeries, capacitance float64, minPoints int) (*HeatLoss, error) {
+	if minPoints <= 0 {
+		minPoints = DefaultMinPoints
+	}
+
+	// Regress dTin/dt on -(Tin - Tout) through the origin.
+	var sxx, sxy float64
+	xs := make([]float64, 0, len(series.Rows))
+	ys := make([]float64, 0, len(series.Rows))
+	for i := 1; i < len(series.Rows); i++ {
+		a, b := series.Rows[i-1], series.Rows[i]
+		if a.SunUp || b.SunUp {
+			continue
+		}
+		inA, inB := meanIndoor(a), meanIndoor(b)
+		if math.IsNaN(inA) || math.IsNaN(inB) || math.IsNaN(a.Outdoor) || math.IsNaN(b.Outdoor) {
+			continue
+		}
+		dt := b.Timestamp - a.Timestamp
+		if dt <= 0 || inB > inA {
+			continue
+		}
+		x := (inA+inB)/2 - (a.Outdoor+b.Outdoor)/2
+		if x <= 0 {
+			continue
+		}
+		y := -(inB - inA) / dt
+		xs = append(xs, x)
+		ys = append(ys, y)
+		sxx += x * x
+		sxy += x * y
+	}
+
+	n := len(xs)
+	if n < minPoints || sxx == 0 {
+		return nil, ErrTooFewPoints
+	}
+
+	rate := sxy / sxx
+	var sse float64
+	for i := range xs {
+		r := ys[i] - rate*xs[i]
+		sse += r * r
+	}
+	se := math.Sqrt(sse / float64(n-1) / sxx)
+	margin := tQuantile975(n-1) * se
+
+	hl := &HeatLoss{
+		Points:   n,
+		Rate:     rate,
+		RateLow:  math.Max(rate-margin, 0),
+		RateHigh: rate + margin,
+	}
+	hl.TimeConstant = timeConstant(hl.Rate)
+	hl.TimeConstantLow = timeConstant(hl.RateHigh)
+	hl.TimeConstantHigh = timeConstant(hl.RateLow)
+	if capacitance > 0 {
+		hl.UA = capacitance * hl.Rate
+		hl.UALow = capacitance * hl.RateLow
+		hl.UAHigh = capacitance * hl.RateHigh
+	}
+	return hl, nil
+}
+
+// meanIndoor averages the sensors that have a value at the row.
+func meanIndoor(row kb.AlignedRow) float64 {
+	var sum float64
+	var n int
+	for _, v := range row.Indoor {
+		if !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum / float64(n)
+}
+
+// timeConstant converts a rate in 1/s to a time constant in hours.
+func timeConstant(rate float64) float64 {
+	if rate <= 0 {
+		return math.Inf(1)
+	}
+	return 1 / rate / 3600
+}
+
+// t97_5 holds the 97.5th percentile of Student's t for 1 to 30 degrees of
+// freedom.
+var t97_5 = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func tQuantile975(df int) float64 {
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(t97_5) {
+		return t97_5[df-1]
+	}
+	return 1.960
+}
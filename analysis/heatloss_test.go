@@ -0,0 +1,151 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// cooldown is an aligned series of a house cooling from tin towards a
+// constant tout at rate 1/s, sampled every interval seconds at night.
+// noise is added to the indoor temperature of every other row.
+func cooldown(rate, tin, tout, interval float64, rows int, noise float64) *kb.AlignedSeries {
+	series := &kb.AlignedSeries{Interval: interval, Sensors: []string{"living_room"}}
+	for i := 0; i < rows; i++ {
+		t := float64(i) * interval
+		in := tout + (tin-tout)*math.Exp(-rate*t)
+		if i%2 == 1 {
+			in += noise
+		}
+		series.Rows = append(series.Rows, kb.AlignedRow{Timestamp: t, Outdoor: tout, Indoor: []float64{in}})
+	}
+	return series
+}
+
+// near tells whether got is within rel of want, relative to want.
+func near(got, want, rel float64) bool {
+	return math.Abs(got-want) <= rel*math.Abs(want)
+}
+
+func TestFitHeatLoss(t *testing.T) {
+	// A 20 hour time constant. The fit works on differences across each
+	// step, which sees the exponential's rate as the discrete one.
+	const interval = 900.0
+	rate := 1 / (20 * 3600.0)
+	discrete := 2 / interval * math.Tanh(rate*interval/2)
+	tests := []struct {
+		name        string
+		series      *kb.AlignedSeries
+		capacitance float64
+		points      int
+		exact       bool
+	}{
+		{"exact cooldown", cooldown(rate, 21, 1, interval, 33, 0), 0, 32, true},
+		{"exact cooldown with capacitance", cooldown(rate, 21, 1, interval, 33, 0), 2e7, 32, true},
+		{"noisy cooldown", cooldown(rate, 21, 1, interval, 33, 0.02), 2e7, 32, false},
+	}
+	for _, tt := range tests {
+		hl, err := FitHeatLoss(tt.series, tt.capacitance, 0)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if hl.Points != tt.points {
+			t.Errorf("%s: %d points, want %d", tt.name, hl.Points, tt.points)
+		}
+		if tt.exact && !near(hl.Rate, discrete, 1e-9) || !near(hl.Rate, rate, 1e-2) {
+			t.Errorf("%s: rate %g, want %g", tt.name, hl.Rate, discrete)
+		}
+		if tt.exact && (!near(hl.RateLow, hl.Rate, 1e-6) || !near(hl.RateHigh, hl.Rate, 1e-6)) {
+			t.Errorf("%s: rate interval [%g, %g] for a noiseless fit, want it around %g", tt.name, hl.RateLow, hl.RateHigh, hl.Rate)
+		}
+		if !tt.exact && (hl.RateLow > discrete || hl.RateHigh < discrete || hl.RateHigh-hl.RateLow > rate/2) {
+			t.Errorf("%s: rate interval [%g, %g] misses %g or is too wide", tt.name, hl.RateLow, hl.RateHigh, discrete)
+		}
+		if !near(hl.TimeConstant, 1/hl.Rate/3600, 1e-9) || hl.TimeConstantLow > hl.TimeConstant || hl.TimeConstantHigh < hl.TimeConstant {
+			t.Errorf("%s: time constant %g in [%g, %g] for rate %g", tt.name, hl.TimeConstant, hl.TimeConstantLow, hl.TimeConstantHigh, hl.Rate)
+		}
+		if tt.capacitance == 0 && (hl.UA != 0 || hl.UALow != 0 || hl.UAHigh != 0) {
+			t.Errorf("%s: UA %g without a capacitance", tt.name, hl.UA)
+		}
+		if tt.capacitance > 0 && (hl.UA != tt.capacitance*hl.Rate || hl.UALow != tt.capacitance*hl.RateLow || hl.UAHigh != tt.capacitance*hl.RateHigh) {
+			t.Errorf("%s: UA %g in [%g, %g] for capacitance %g and rate %g", tt.name, hl.UA, hl.UALow, hl.UAHigh, tt.capacitance, hl.Rate)
+		}
+	}
+}
+
+func TestFitHeatLossTooFewPoints(t *testing.T) {
+	daytime := cooldown(1/(20*3600.0), 21, 1, 900, 33, 0)
+	for i := range daytime.Rows {
+		daytime.Rows[i].SunUp = true
+	}
+	warmOutside := cooldown(1/(20*3600.0), 21, 25, 900, 33, 0)
+	tests := []struct {
+		name      string
+		series    *kb.AlignedSeries
+		minPoints int
+	}{
+		{"empty", &kb.AlignedSeries{Interval: 900}, 0},
+		{"one row", cooldown(1/(20*3600.0), 21, 1, 900, 1, 0), 0},
+		{"below the default", cooldown(1/(20*3600.0), 21, 1, 900, DefaultMinPoints, 0), 0},
+		{"below the given minimum", cooldown(1/(20*3600.0), 21, 1, 900, 20, 0), 20},
+		{"daytime", daytime, 0},
+		{"warming", warmOutside, 0},
+	}
+	for _, tt := range tests {
+		if hl, err := FitHeatLoss(tt.series, 0, tt.minPoints); err != ErrTooFewPoints {
+			t.Errorf("%s: FitHeatLoss = %+v, %v, want %v", tt.name, hl, err, ErrTooFewPoints)
+		}
+	}
+}
+
+// A house that does not cool has no variance to fit: the rate is 0 and the
+// time constant unbounded.
+func TestFitHeatLossFlat(t *testing.T) {
+	hl, err := FitHeatLoss(cooldown(0, 21, 1, 900, 33, 0), 2e7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hl.Rate != 0 || hl.RateLow != 0 || hl.RateHigh != 0 || hl.UA != 0 {
+		t.Errorf("rate %g in [%g, %g], UA %g, want 0", hl.Rate, hl.RateLow, hl.RateHigh, hl.UA)
+	}
+	if !math.IsInf(hl.TimeConstant, 1) || !math.IsInf(hl.TimeConstantLow, 1) || !math.IsInf(hl.TimeConstantHigh, 1) {
+		t.Errorf("time constant %g in [%g, %g], want +Inf", hl.TimeConstant, hl.TimeConstantLow, hl.TimeConstantHigh)
+	}
+}
+
+func TestTQuantile975(t *testing.T) {
+	tests := []struct {
+		df   int
+		want float64
+	}{
+		{-1, math.Inf(1)},
+		{0, math.Inf(1)},
+		{1, 12.706},
+		{2, 4.303},
+		{10, 2.228},
+		{30, 2.042},
+		{31, 1.960},
+		{1000, 1.960},
+	}
+	for _, tt := range tests {
+		if got := tQuantile975(tt.df); got != tt.want {
+			t.Errorf("tQuantile975(%d) = %v, want %v", tt.df, got, tt.want)
+		}
+	}
+}
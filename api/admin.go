@@ -0,0 +1,259 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"lachut.net/gogs/dslachut/go-irleak/ext"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type adminUserBody struct {
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Disabled *bool  `json:"disabled"`
+}
+
+type adminUserResponse struct {
+	apiResponse
+	Users []kb.Account `json:"users"`
+}
+
+type adminDeleteResponse struct {
+	apiResponse
+	Archive *kb.UserArchive `json:"archive,omitempty"`
+}
+
+// AdminUserHandler lets admins create, list, change and delete users.
+// blobs holds the thermal frames of users being deleted, if any.
+func AdminUserHandler(w http.ResponseWriter, r *http.Request, k kb.KB, blobs ext.BlobStore) {
+	if r.Method == "POST" {
+		adminUserPost(w, r, k)
+	} else if r.Method == "GET" {
+		adminUserGet(w, r, k)
+	} else if r.Method == "PUT" {
+		adminUserPut(w, r, k)
+	} else if r.Method == "DELETE" {
+		adminUserDelete(w, r, k, blobs)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func readAdminUserBody(w http.ResponseWriter, r *http.Request) (*adminUserBody, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return nil, false
+	}
+
+	rec := new(adminUserBody)
+	if json.Unmarshal(body, rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return nil, false
+	}
+	return rec, true
+}
+
+func adminUserGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	_, newToken, ok := checkRequestToken(w, r, "", permAdmin, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	ur := new(adminUserResponse)
+	ur.Token = newToken
+	ur.Success = true
+	if user := r.URL.Query().Get("user"); user != "" {
+		account, ok := k.GetAccount(user)
+		if !ok {
+			requestFailedToken(w, http.StatusNotFound, newToken)
+			log.Printf("user '%s' not found\n", user)
+			return
+		}
+		ur.Users = []kb.Account{account}
+	} else {
+		ur.Users = k.ListAccounts()
+		if ur.Users == nil {
+			ur.Users = []kb.Account{}
+		}
+	}
+	payload, _ := json.Marshal(ur)
+	w.Write(payload)
+}
+
+func adminUserPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readAdminUserBody(w, r)
+	if !ok {
+		return
+	}
+
+	admin, newToken, ok := checkRequestToken(w, r, rec.Token, permAdmin, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if rec.Role == "" {
+		rec.Role = kb.RoleUser
+	}
+	if rec.User == "" || rec.Password == "" || !kb.ValidRole(rec.Role) {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad new user '%s' with role '%s'\n", rec.User, rec.Role)
+		return
+	}
+
+	hash, err := HashPassword(rec.Password)
+	if err != nil {
+		requestFailedToken(w, http.StatusInternalServerError, newToken)
+		log.Println(err)
+		return
+	}
+	if !k.AddUser(rec.User, hash) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not add user '%s'\n", rec.User)
+		return
+	}
+	ok = k.SetRole(rec.User, rec.Role)
+	if ok && rec.Disabled != nil {
+		ok = k.SetDisabled(rec.User, *rec.Disabled)
+	}
+	audit(r, k, admin, kb.AuditUserCreated, rec.User, "role "+rec.Role)
+
+	adminUserRespond(w, rec.User, ok, newToken, k)
+}
+
+func adminUserPut(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readAdminUserBody(w, r)
+	if !ok {
+		return
+	}
+
+	admin, newToken, ok := checkRequestToken(w, r, rec.Token, permAdmin, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if _, ok = k.GetAccount(rec.User); !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("user '%s' not found\n", rec.User)
+		return
+	}
+	if rec.Role != "" && !kb.ValidRole(rec.Role) {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad role '%s'\n", rec.Role)
+		return
+	}
+	if rec.User == admin && (rec.Role != "" && rec.Role != kb.RoleAdmin || rec.Disabled != nil && *rec.Disabled) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("admin '%s' may not demote or disable themselves\n", admin)
+		return
+	}
+
+	ok = true
+	if rec.Role != "" {
+		ok = k.SetRole(rec.User, rec.Role)
+	}
+	if ok && rec.Disabled != nil {
+		ok = k.SetDisabled(rec.User, *rec.Disabled)
+	}
+	if ok {
+		account, _ := k.GetAccount(rec.User)
+		audit(r, k, admin, kb.AuditUserChanged, rec.User, fmt.Sprintf("role %s, disabled %v", account.Role, account.Disabled))
+	}
+
+	adminUserRespond(w, rec.User, ok, newToken, k)
+}
+
+func adminUserDelete(w http.ResponseWriter, r *http.Request, k kb.KB, blobs ext.BlobStore) {
+	admin, newToken, ok := checkRequestToken(w, r, "", permAdmin, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	params := r.URL.Query()
+	user := params.Get("user")
+	if _, ok = k.GetAccount(user); !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("user '%s' not found\n", user)
+		return
+	}
+	if user == admin {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("admin '%s' may not delete themselves\n", admin)
+		return
+	}
+	if err := CheckDeleteUser(k, user); err != nil {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Println(err)
+		return
+	}
+
+	// With archive=true the user's data is sent back before it is deleted.
+	dr := new(adminDeleteResponse)
+	if params.Get("archive") == "true" {
+		if dr.Archive, ok = kb.ExportUser(k, user); !ok {
+			requestFailedToken(w, http.StatusInternalServerError, newToken)
+			log.Printf("could not archive user '%s'\n", user)
+			return
+		}
+	}
+
+	dr.Token = newToken
+	if err := DeleteUser(k, blobs, user); err != nil {
+		log.Println(err)
+	} else {
+		dr.Success = true
+	}
+	if !dr.Success {
+		dr.Archive = nil
+	} else if dr.Archive != nil {
+		audit(r, k, admin, kb.AuditUserDeleted, user, "with all data, archived")
+	} else {
+		audit(r, k, admin, kb.AuditUserDeleted, user, "with all data")
+	}
+	payload, _ := json.Marshal(dr)
+	w.Write(payload)
+}
+
+// adminUserRespond answers with the account as it now stands.
+func adminUserRespond(w http.ResponseWriter, user string, ok bool, newToken string, k kb.KB) {
+	ur := new(adminUserResponse)
+	ur.Token = newToken
+	ur.Success = ok
+	ur.Users = []kb.Account{}
+	if account, found := k.GetAccount(user); found {
+		ur.Users = append(ur.Users, account)
+	}
+	payload, _ := json.Marshal(ur)
+	w.Write(payload)
+}
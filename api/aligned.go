@@ -0,0 +1,145 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// defaultInterval is the grid spacing in seconds when none is requested.
+const defaultInterval = 900
+
+type alignedResponse struct {
+	apiResponse
+	Location kb.Location   `json:"location"`
+	Interval float64       `json:"interval"`
+	Columns  []string      `json:"columns"`
+	Rows     [][]jsonFloat `json:"rows"`
+}
+
+// jsonFloat marshals NaN as null.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func AlignedHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "GET" {
+		alignedGet(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func alignedGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	start_ts, end_ts, ok := timeRange(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad time range\n")
+		return
+	}
+
+	loc, ok := userLocation(w, params, user, newToken, k)
+	if !ok {
+		return
+	}
+
+	interval, ok := gridInterval(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad interval %s\n", params.Get("interval"))
+		return
+	}
+
+	var fill kb.Fill
+	switch params.Get("fill") {
+	case "", "linear":
+		fill = kb.FillLinear
+	case "previous":
+		fill = kb.FillPrevious
+	default:
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad fill method %s\n", params.Get("fill"))
+		return
+	}
+
+	series, ok := k.GetAlignedSeries(user, loc.ID, params["sensor"], start_ts, end_ts, interval, fill)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("could not align series for user '%s'\n", user)
+		return
+	}
+
+	columns := []string{"timestamp", "outdoor"}
+	for _, sensor := range series.Sensors {
+		columns = append(columns, sensor, sensor+"_delta")
+	}
+	rows := make([][]jsonFloat, 0, len(series.Rows))
+	for _, row := range series.Rows {
+		out := make([]jsonFloat, 0, len(columns))
+		out = append(out, jsonFloat(row.Timestamp), jsonFloat(row.Outdoor))
+		for _, indoor := range row.Indoor {
+			out = append(out, jsonFloat(indoor), jsonFloat(indoor-row.Outdoor))
+		}
+		rows = append(rows, out)
+	}
+
+	if params.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		record := make([]string, len(columns))
+		for _, row := range rows {
+			for i, v := range row {
+				record[i] = ""
+				if !math.IsNaN(float64(v)) {
+					record[i] = strconv.FormatFloat(float64(v), 'f', -1, 64)
+				}
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return
+	}
+
+	ar := new(alignedResponse)
+	ar.Token = newToken
+	ar.Success = true
+	ar.Location = loc
+	ar.Interval = interval
+	ar.Columns = columns
+	ar.Rows = rows
+	payload, _ := json.Marshal(ar)
+	w.Write(payload)
+}
@@ -0,0 +1,178 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	"lachut.net/gogs/dslachut/go-irleak/analysis"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type heatLossResponse struct {
+	apiResponse
+	Location         kb.Location `json:"location"`
+	Points           int         `json:"points"`
+	Rate             jsonFloat   `json:"rate"`
+	RateLow          jsonFloat   `json:"rate_low"`
+	RateHigh         jsonFloat   `json:"rate_high"`
+	TimeConstant     jsonFloat   `json:"time_constant"`
+	TimeConstantLow  jsonFloat   `json:"time_constant_low"`
+	TimeConstantHigh jsonFloat   `json:"time_constant_high"`
+	UA               jsonFloat   `json:"ua,omitempty"`
+	UALow            jsonFloat   `json:"ua_low,omitempty"`
+	UAHigh           jsonFloat   `json:"ua_high,omitempty"`
+}
+
+func HeatLossHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "GET" {
+		heatLossGet(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func heatLossGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	start_ts, end_ts, ok := timeRange(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad time range\n")
+		return
+	}
+	loc, ok := userLocation(w, params, user, newToken, k)
+	if !ok {
+		return
+	}
+
+	p := analysis.HeatLossParams{Capacitance: viper.GetFloat64("capacitance")}
+	p.Interval, ok = gridInterval(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad interval %s\n", params.Get("interval"))
+		return
+	}
+	if v := params.Get("capacitance"); v != "" {
+		c, err := strconv.ParseFloat(v, 64)
+		if err != nil || c < 0 {
+			requestFailedToken(w, http.StatusBadRequest, newToken)
+			log.Printf("bad capacitance %s\n", v)
+			return
+		}
+		p.Capacitance = c
+	}
+
+	hl, err := analysis.EstimateHeatLoss(k, user, loc.ID, params["sensor"], start_ts, end_ts, p)
+	if err != nil {
+		requestFailedToken(w, http.StatusUnprocessableEntity, newToken)
+		log.Println(err)
+		return
+	}
+
+	hr := heatLossResponse{
+		apiResponse:      apiResponse{true, newToken},
+		Location:         loc,
+		Points:           hl.Points,
+		Rate:             jsonFloat(hl.Rate),
+		RateLow:          jsonFloat(hl.RateLow),
+		RateHigh:         jsonFloat(hl.RateHigh),
+		TimeConstant:     jsonFloat(hl.TimeConstant),
+		TimeConstantLow:  jsonFloat(hl.TimeConstantLow),
+		TimeConstantHigh: jsonFloat(hl.TimeConstantHigh),
+		UA:               jsonFloat(hl.UA),
+		UALow:            jsonFloat(hl.UALow),
+		UAHigh:           jsonFloat(hl.UAHigh),
+	}
+	payload, _ := json.Marshal(hr)
+	w.Write(payload)
+}
+
+type degreeDayResponse struct {
+	apiResponse
+	Location kb.Location `json:"location"`
+	*analysis.DegreeDays
+}
+
+func DegreeDayHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "GET" {
+		degreeDayGet(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func degreeDayGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	start_ts, end_ts, ok := timeRange(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad time range\n")
+		return
+	}
+	loc, ok := userLocation(w, params, user, newToken, k)
+	if !ok {
+		return
+	}
+
+	base := analysis.DefaultDegreeDayBase
+	if viper.IsSet("degreedaybase") {
+		base = viper.GetFloat64("degreedaybase")
+	}
+	if v := params.Get("base"); v != "" {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			requestFailedToken(w, http.StatusBadRequest, newToken)
+			log.Printf("bad base temperature %s\n", v)
+			return
+		}
+		base = b
+	}
+	tz := time.UTC
+	if v := params.Get("tz"); v != "" {
+		var err error
+		if tz, err = time.LoadLocation(v); err != nil {
+			requestFailedToken(w, http.StatusBadRequest, newToken)
+			log.Printf("bad time zone %s\n", v)
+			return
+		}
+	}
+
+	dr := degreeDayResponse{
+		apiResponse: apiResponse{true, newToken},
+		Location:    loc,
+		DegreeDays:  analysis.EstimateDegreeDays(k, loc.ID, start_ts, end_ts, base, tz),
+	}
+	payload, _ := json.Marshal(dr)
+	w.Write(payload)
+}
@@ -16,17 +16,109 @@ package api
 
 import (
 	"encoding/json"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	s "strings"
+
+	"github.com/spf13/viper"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
 )
 
+// tokenHeader carries the rotated token on every response, alongside the
+// token field of the JSON body.
+const tokenHeader = "X-Irleak-Token"
+
 type apiResponse struct {
 	Success bool   `json:"success"`
 	Token   string `json:"token"`
 }
 
 func requestFailed(w http.ResponseWriter, status int) {
-	failure := apiResponse{false, ""}
+	requestFailedToken(w, status, "")
+}
+
+// requestFailedToken reports a failure after checkToken has already rotated
+// the caller's token, so the client can carry on with the new one.
+func requestFailedToken(w http.ResponseWriter, status int, newToken string) {
+	if newToken != "" {
+		w.Header().Set(tokenHeader, newToken)
+	}
+	failure := apiResponse{false, newToken}
 	payload, _ := json.Marshal(failure)
 	w.WriteHeader(status)
 	w.Write(payload)
 }
+
+// clientIP returns the address of the caller. Behind a reverse proxy that is
+// trusted with trustproxy it is taken from X-Real-IP or the last address the
+// proxy appended to X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	if viper.GetBool("trustproxy") {
+		if ip := s.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := s.Split(fwd, ",")
+			return s.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// timeRange reads the optional start and end query parameters, defaulting to
+// the whole time line.
+func timeRange(params url.Values) (start, end float64, ok bool) {
+	start, end = 0, math.MaxFloat64
+	var err error
+	if v := params.Get("start"); v != "" {
+		if start, err = strconv.ParseFloat(v, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if v := params.Get("end"); v != "" {
+		if end, err = strconv.ParseFloat(v, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, end, true
+}
+
+// userLocation looks up the location named by the location query parameter
+// among the user's own, answering the request itself when it can't.
+func userLocation(w http.ResponseWriter, params url.Values, user, newToken string, k kb.KB) (kb.Location, bool) {
+	l_id, err := strconv.ParseInt(params.Get("location"), 10, 64)
+	if err != nil {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad location id %s\n", params.Get("location"))
+		return kb.Location{}, false
+	}
+	loc, ok := k.GetLocation(user, l_id)
+	if !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("location %d not found for user '%s'\n", l_id, user)
+		return kb.Location{}, false
+	}
+	return loc, true
+}
+
+// gridInterval reads the optional interval query parameter in seconds.
+func gridInterval(params url.Values) (float64, bool) {
+	v := params.Get("interval")
+	if v == "" {
+		return defaultInterval, true
+	}
+	i, err := strconv.ParseFloat(v, 64)
+	if err != nil || i <= 0 {
+		return 0, false
+	}
+	return i, true
+}
@@ -0,0 +1,215 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	s "strings"
+	"time"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// Device keys look like irk_<16 hex id>_<48 hex secret>. The id is stored in
+// the clear to find the key, the secret only as a SHA-256 hash; it is random
+// enough that a slow password hash buys nothing.
+const apiKeyPrefix = "irk_"
+
+type apiKeyPostBody struct {
+	Token   string   `json:"token"`
+	Name    string   `json:"name"`
+	Sensors []string `json:"sensors"`
+}
+
+type apiKeyResponse struct {
+	apiResponse
+	Key  string      `json:"key,omitempty"`
+	Keys []kb.APIKey `json:"keys"`
+}
+
+// NewAPIKey mints a device key for user limited to sensors, stores it and
+// returns the full key, which can't be recovered later.
+func NewAPIKey(k kb.KB, user, name string, sensors []string) (string, kb.APIKey, error) {
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 24)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", kb.APIKey{}, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", kb.APIKey{}, err
+	}
+	if sensors == nil {
+		sensors = []string{}
+	}
+	secret := fmt.Sprintf("%x", secretBytes)
+	key := kb.APIKey{
+		ID:      fmt.Sprintf("%x", idBytes),
+		User:    user,
+		Name:    name,
+		Hash:    hashAPISecret(secret),
+		Sensors: sensors,
+		Created: time.Now().Unix(),
+	}
+	if !k.AddAPIKey(user, key) {
+		return "", kb.APIKey{}, fmt.Errorf("could not store key '%s' for user '%s'", name, user)
+	}
+	return apiKeyPrefix + key.ID + "_" + secret, key, nil
+}
+
+func hashAPISecret(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
+
+func isAPIKey(token string) bool {
+	return s.HasPrefix(token, apiKeyPrefix)
+}
+
+// checkAPIKey returns the owner of an active device key and the sensors it
+// is limited to, nil meaning any.
+func checkAPIKey(token string, k kb.KB) (user string, sensors []string, ok bool) {
+	parts := s.Split(s.TrimPrefix(token, apiKeyPrefix), "_")
+	if !isAPIKey(token) || len(parts) != 2 {
+		return "", nil, false
+	}
+	key, ok := k.GetAPIKey(parts[0])
+	if !ok || key.Revoked != 0 {
+		return "", nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPISecret(parts[1])), []byte(key.Hash)) != 1 {
+		return "", nil, false
+	}
+	if len(key.Sensors) == 0 {
+		return key.User, nil, true
+	}
+	return key.User, key.Sensors, true
+}
+
+// sensorAllowed reports whether a key limited to sensors may use sensor.
+func sensorAllowed(sensors []string, sensor string) bool {
+	if sensors == nil {
+		return true
+	}
+	for _, allowed := range sensors {
+		if allowed == sensor {
+			return true
+		}
+	}
+	return false
+}
+
+func APIKeyHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		apiKeyPost(w, r, k)
+	} else if r.Method == "GET" {
+		apiKeyGet(w, r, k)
+	} else if r.Method == "DELETE" {
+		apiKeyDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func apiKeyPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return
+	}
+
+	rec := apiKeyPostBody{}
+	if json.Unmarshal(body, &rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if rec.Name == "" {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("missing key name\n")
+		return
+	}
+
+	full, key, err := NewAPIKey(k, user, rec.Name, rec.Sensors)
+	if err != nil {
+		requestFailedToken(w, http.StatusInternalServerError, newToken)
+		log.Println(err)
+		return
+	}
+
+	audit(r, k, user, kb.AuditKeyCreated, user, "key "+key.ID)
+	kr := new(apiKeyResponse)
+	kr.Token = newToken
+	kr.Success = true
+	kr.Key = full
+	kr.Keys = []kb.APIKey{key}
+	payload, _ := json.Marshal(kr)
+	w.Write(payload)
+}
+
+func apiKeyGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	kr := new(apiKeyResponse)
+	kr.Token = newToken
+	kr.Success = true
+	kr.Keys = k.ListAPIKeys(user)
+	if kr.Keys == nil {
+		kr.Keys = []kb.APIKey{}
+	}
+	payload, _ := json.Marshal(kr)
+	w.Write(payload)
+}
+
+func apiKeyDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if !k.RevokeAPIKey(user, params.Get("id"), time.Now().Unix()) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("no active key %s for user '%s'\n", params.Get("id"), user)
+		return
+	}
+	audit(r, k, user, kb.AuditKeyRevoked, user, "key "+params.Get("id"))
+
+	success := apiResponse{true, newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
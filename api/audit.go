@@ -0,0 +1,44 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// Audit adds an event to the audit log. The action that caused it has
+// already happened, so failing to record it is only logged.
+func Audit(k kb.KB, user, action, subject, clientIP, detail string) {
+	event := kb.AuditEvent{
+		Timestamp: time.Now().Unix(),
+		User:      user,
+		Action:    action,
+		Subject:   subject,
+		ClientIP:  clientIP,
+		Detail:    detail,
+	}
+	if !k.AddAudit(event) {
+		log.Printf("could not record audit event %s by '%s' on '%s'\n", action, user, subject)
+	}
+}
+
+// audit records an event caused by an API request.
+func audit(r *http.Request, k kb.KB, user, action, subject, detail string) {
+	Audit(k, user, action, subject, clientIP(r), detail)
+}
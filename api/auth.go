@@ -21,13 +21,16 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	s "strings"
 	"time"
 
-	"github.com/elithrar/simple-scrypt"
 	"github.com/spf13/viper"
 	"lachut.net/gogs/dslachut/go-irleak/kb"
 )
 
+const bearerPrefix = "Bearer "
+
 type authPostBody struct {
 	User string `json:"user"`
 	Pass string `json:"password"`
@@ -38,6 +41,8 @@ func AuthHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
 		authPost(w, r, k)
 		//} else if r.Method == "GET" {
 		//	authGet(w, r)
+	} else if r.Method == "DELETE" {
+		authDelete(w, r, k)
 	} else {
 		requestFailed(w, http.StatusBadRequest)
 		log.Printf("bad request method %s\n", r.Method)
@@ -65,33 +70,97 @@ func authPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
 		return
 	}
 
-	hash, ok := k.GetHash(rec.User)
-	if !ok {
-		requestFailed(w, http.StatusNotFound)
-		log.Printf("user '%s' not found\n", rec.User)
+	ip := clientIP(r)
+	if wait := lockoutWait(rec.User, ip, k); wait > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(wait, 10))
+		requestFailed(w, http.StatusTooManyRequests)
+		log.Printf("login for '%s' from %s locked out\n", rec.User, ip)
+		audit(r, k, rec.User, kb.AuditLoginFailed, rec.User, "locked out")
 		return
 	}
 
-	err = scrypt.CompareHashAndPassword(hash, []byte(rec.Pass))
-	if err != nil {
+	// Unknown users, wrong passwords and disabled accounts all get the same
+	// answer so that user names cannot be probed.
+	hash, found := k.GetHash(rec.User)
+	ok := comparePassword(hash, found, rec.Pass)
+	if ok {
+		account, found := k.GetAccount(rec.User)
+		ok = found && !account.Disabled
+	}
+	if !ok {
+		recordFailure(kb.FailureUser, rec.User, k)
+		recordFailure(kb.FailureIP, ip, k)
 		requestFailed(w, http.StatusForbidden)
-		log.Printf("bad password\n")
+		log.Printf("failed login for '%s' from %s\n", rec.User, ip)
+		audit(r, k, rec.User, kb.AuditLoginFailed, rec.User, "")
 		return
 	}
+	k.ClearLoginFailure(kb.FailureUser, rec.User)
+	upgradeHash(rec.User, rec.Pass, hash, k)
 
-	token, err := generateToken(rec.User, k)
+	session, err := newSession(rec.User, ip, k)
+	if err != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("session creating error\n")
+		return
+	}
+	token, err := generateToken(rec.User, session, k)
 	if err != nil || token == "" {
 		requestFailed(w, http.StatusInternalServerError)
 		log.Printf("token generating error\n")
 		return
 	}
 
+	audit(r, k, rec.User, kb.AuditLogin, rec.User, "session "+session)
+	w.Header().Set(tokenHeader, token)
 	success := apiResponse{true, token}
 	payload, _ := json.Marshal(success)
 	w.Write(payload)
 }
 
-func generateToken(user string, k kb.KB) (string, error) {
+// authDelete logs out by invalidating the caller's token.
+func authDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	token := requestToken(r, "")
+	user, session, ok := tokenSession(token, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	success := apiResponse{revokeToken(token, k), ""}
+	if success.Success {
+		audit(r, k, user, kb.AuditLogout, user, "session "+session)
+	}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// newSession starts a session for a user logging in from clientIP.
+func newSession(user, clientIP string, k kb.KB) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	session := kb.Session{
+		ID:       fmt.Sprintf("%x", idBytes),
+		User:     user,
+		Created:  now,
+		Expires:  now + viper.GetInt64("exptoken"),
+		LastUsed: now,
+		ClientIP: clientIP,
+	}
+	if !k.AddSession(session) {
+		return "", fmt.Errorf("could not store session for user '%s'", user)
+	}
+	return session.ID, nil
+}
+
+func generateToken(user, session string, k kb.KB) (string, error) {
+	if signedTokens() {
+		return generateSignedToken(user, session)
+	}
 	tokenBytes := make([]byte, 16)
 	_, err := rand.Read(tokenBytes)
 	if err != nil {
@@ -99,35 +168,116 @@ func generateToken(user string, k kb.KB) (string, error) {
 	}
 	token := fmt.Sprintf("%x", tokenBytes)
 	exp := time.Now().Unix() + viper.GetInt64("exptoken")
-	k.AddToken(user, token, exp)
+	k.AddToken(user, token, exp, session)
 	return token, nil
 }
 
-func checkToken(token string, k kb.KB) (user string, newToken string, ok bool) {
+func checkToken(token, clientIP string, k kb.KB) (user string, newToken string, ok bool) {
+	if s.HasPrefix(token, signedPrefix) {
+		return checkSignedToken(token, clientIP, k)
+	}
 	now := time.Now().Unix()
-	user, exp, ok := k.GetUser(token)
+	user, exp, session, ok := k.GetUser(token)
 	if !ok || exp < now || user == "" {
 		log.Printf("%v.%v.%v.%v\n", user, exp, ok, token)
 		return "", "", false
 	}
 
-	newToken, err := generateToken(user, k)
+	newToken, err := generateToken(user, session, k)
 	if err != nil {
 		log.Println(err)
 		return "", "", ok
 	}
 
 	k.ExpireToken(token)
+	if session != "" {
+		k.TouchSession(session, now+viper.GetInt64("exptoken"), now, clientIP)
+	}
 	return
 }
 
+// checkRequestToken checks the token the request carries, see requestToken,
+// and that the caller's role grants perm. Only then is the token rotated,
+// and its replacement put in the response header as well.
+func checkRequestToken(w http.ResponseWriter, r *http.Request, bodyToken string, perm permission, k kb.KB) (user string, newToken string, ok bool) {
+	token := requestToken(r, bodyToken)
+	user, ok = tokenUser(token, k)
+	if !ok || !authorized(user, perm, k) {
+		return "", "", false
+	}
+	user, newToken, ok = checkToken(token, clientIP(r), k)
+	if ok && newToken != "" {
+		w.Header().Set(tokenHeader, newToken)
+	}
+	return
+}
+
+// requestToken finds the caller's token in the Authorization header, then
+// the JSON body, then the query string unless querytokens is turned off.
+func requestToken(r *http.Request, bodyToken string) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > len(bearerPrefix) && s.EqualFold(h[:len(bearerPrefix)], bearerPrefix) {
+		return s.TrimSpace(h[len(bearerPrefix):])
+	}
+	if bodyToken != "" {
+		return bodyToken
+	}
+	if viper.IsSet("querytokens") && !viper.GetBool("querytokens") {
+		if r.URL.Query().Get("token") != "" {
+			log.Printf("query string token rejected\n")
+		}
+		return ""
+	}
+	return r.URL.Query().Get("token")
+}
+
+// tokenUser validates a token of either kind without rotating it.
+func tokenUser(token string, k kb.KB) (string, bool) {
+	user, _, ok := tokenSession(token, k)
+	return user, ok
+}
+
+// tokenSession validates a token of either kind without rotating it and
+// returns its user and session. Tokens from before sessions were recorded
+// have none.
+func tokenSession(token string, k kb.KB) (user string, session string, ok bool) {
+	if s.HasPrefix(token, signedPrefix) {
+		claims, ok := validSignedToken(token)
+		if !ok {
+			return "", "", false
+		}
+		return claims.User, claims.Session, true
+	}
+	user, exp, session, ok := k.GetUser(token)
+	if !ok || exp < time.Now().Unix() || user == "" {
+		return "", "", false
+	}
+	return user, session, true
+}
+
+// revokeToken invalidates a token of either kind right away, along with
+// the rest of its session.
+func revokeToken(token string, k kb.KB) bool {
+	if user, session, ok := tokenSession(token, k); ok && session != "" {
+		return RevokeSession(k, user, session)
+	}
+	if s.HasPrefix(token, signedPrefix) {
+		return revokeSignedToken(token, k)
+	}
+	return k.ExpireToken(token)
+}
+
 func PurgeTokens(k kb.KB, done chan bool) {
+	LoadRevoked(k)
 	tick := time.NewTicker(time.Second * time.Duration(viper.GetInt64("exptoken")))
+	refresh := time.NewTicker(time.Second * time.Duration(viper.GetInt64("revokedrefresh")))
 	for {
 		select {
 		case <-tick.C:
 			now := time.Now().Unix()
 			k.PurgeTokens(now)
+		case <-refresh.C:
+			LoadRevoked(k)
 		case <-done:
 			return
 		}
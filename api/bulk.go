@@ -0,0 +1,224 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	s "strings"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// Bulk uploads are committed every bulkBatchSize rows, so a long upload
+// holds the database for one batch at a time.
+const bulkBatchSize = 500
+
+const maxBulkLine = 64 << 10
+
+// errBadRow marks a line that can't be read as a reading. The upload goes
+// on with the next line.
+var errBadRow = errors.New("malformed row")
+
+type bulkRow struct {
+	User      string   `json:"user"`
+	Sensor    string   `json:"sensor"`
+	Timestamp *float64 `json:"timestamp"`
+	Value     *float64 `json:"value"`
+}
+
+type bulkResponse struct {
+	apiResponse
+	Accepted  int64 `json:"accepted"`
+	Duplicate int64 `json:"duplicate"`
+	Rejected  int64 `json:"rejected"`
+}
+
+// bulkReader returns the rows of an upload one at a time, io.EOF at the end
+// and errBadRow for lines to skip. Any other error ends the upload.
+type bulkReader interface {
+	next() (bulkRow, error)
+}
+
+func isBulkUpload(contentType string) bool {
+	return contentType == "text/csv" || contentType == "application/x-ndjson"
+}
+
+// temperatureBulkPost streams temperatures of any number of sensors from a
+// CSV or NDJSON body, one reading per line. Lines for another user, for
+// sensors a device key doesn't cover, that can't be read or that fail the
+// server's validation rules are rejected; readings already stored are
+// counted as duplicates. The token can't be in the body, so it comes from
+// the Authorization header or the query.
+func temperatureBulkPost(w http.ResponseWriter, r *http.Request, contentType string, k kb.KB) {
+	user, newToken, allowed, ok := temperatureAuth(w, r, "", permUpload, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	var rows bulkReader
+	if contentType == "text/csv" {
+		rows = newCSVRows(r.Body)
+	} else {
+		rows = newNDJSONRows(r.Body)
+	}
+
+	rules := temperatureRules()
+	unit, _ := kb.DefaultUnit(kb.KindTemperature)
+	br := new(bulkResponse)
+	br.Token = newToken
+	br.Success = true
+	status := http.StatusOK
+	batch := make([]kb.Measurement, 0, bulkBatchSize)
+	failed := false
+	flush := func() bool {
+		added, ok := k.AddMeasurements(batch)
+		if !ok {
+			failed = true
+			br.Success = false
+			status = http.StatusInternalServerError
+			log.Printf("could not store bulk upload for user '%s'\n", user)
+			return false
+		}
+		br.Accepted += added
+		br.Duplicate += int64(len(batch)) - added
+		batch = batch[:0]
+		return true
+	}
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		} else if err == errBadRow || err == nil && !validBulkRow(row, user, allowed, rules) {
+			br.Rejected++
+			continue
+		} else if err != nil {
+			br.Success = false
+			status = http.StatusBadRequest
+			log.Printf("bulk upload stopped: %v\n", err)
+			break
+		}
+		batch = append(batch, kb.Measurement{Owner: user, Sensor: row.Sensor, Kind: kb.KindTemperature, Unit: unit, Timestamp: *row.Timestamp, Value: *row.Value})
+		if len(batch) == bulkBatchSize && !flush() {
+			break
+		}
+	}
+	if !failed && len(batch) > 0 {
+		flush()
+	}
+	audit(r, k, user, kb.AuditUpload, user, fmt.Sprintf("bulk upload, %d accepted, %d duplicate, %d rejected", br.Accepted, br.Duplicate, br.Rejected))
+
+	payload, _ := json.Marshal(br)
+	w.WriteHeader(status)
+	w.Write(payload)
+}
+
+func validBulkRow(row bulkRow, user string, allowed []string, rules readingRules) bool {
+	return (row.User == "" || row.User == user) && row.Sensor != "" && sensorAllowed(allowed, row.Sensor) &&
+		rules.check(kb.Reading{Timestamp: *row.Timestamp, Value: *row.Value}) == ""
+}
+
+// csvRows reads user,sensor,timestamp,value lines. Each line is parsed on
+// its own, so a stray quote only spoils its line. A first line starting
+// with user is taken for a header and skipped.
+type csvRows struct {
+	sc    *bufio.Scanner
+	first bool
+}
+
+func newCSVRows(body io.Reader) *csvRows {
+	return &csvRows{newLineScanner(body), true}
+}
+
+func (c *csvRows) next() (bulkRow, error) {
+	for c.sc.Scan() {
+		line := s.TrimSpace(c.sc.Text())
+		if line == "" {
+			continue
+		}
+		r := csv.NewReader(s.NewReader(line))
+		r.TrimLeadingSpace = true
+		rec, err := r.Read()
+		if c.first {
+			c.first = false
+			if err == nil && s.EqualFold(s.TrimSpace(rec[0]), "user") {
+				continue
+			}
+		}
+		if err != nil || len(rec) != 4 {
+			return bulkRow{}, errBadRow
+		}
+		ts, err := strconv.ParseFloat(s.TrimSpace(rec[2]), 64)
+		if err != nil {
+			return bulkRow{}, errBadRow
+		}
+		value, err := strconv.ParseFloat(s.TrimSpace(rec[3]), 64)
+		if err != nil {
+			return bulkRow{}, errBadRow
+		}
+		return bulkRow{s.TrimSpace(rec[0]), s.TrimSpace(rec[1]), &ts, &value}, nil
+	}
+	return bulkRow{}, scanEnd(c.sc)
+}
+
+// ndjsonRows reads one JSON object with user, sensor, timestamp and value
+// per line. Blank lines are skipped.
+type ndjsonRows struct {
+	sc *bufio.Scanner
+}
+
+func newNDJSONRows(body io.Reader) *ndjsonRows {
+	return &ndjsonRows{newLineScanner(body)}
+}
+
+func (n *ndjsonRows) next() (bulkRow, error) {
+	for n.sc.Scan() {
+		line := s.TrimSpace(n.sc.Text())
+		if line == "" {
+			continue
+		}
+		var row bulkRow
+		if json.Unmarshal([]byte(line), &row) != nil || row.Timestamp == nil || row.Value == nil {
+			return bulkRow{}, errBadRow
+		}
+		return row, nil
+	}
+	return bulkRow{}, scanEnd(n.sc)
+}
+
+// newLineScanner splits an upload into lines of up to maxBulkLine bytes.
+// A longer line ends the upload.
+func newLineScanner(body io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 4096), maxBulkLine)
+	return sc
+}
+
+// scanEnd is the error a finished scanner leaves the upload with.
+func scanEnd(sc *bufio.Scanner) error {
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
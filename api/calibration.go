@@ -0,0 +1,156 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type calibrationBody struct {
+	Token     string   `json:"token"`
+	Sensor    string   `json:"sensor"`
+	Effective float64  `json:"effective"`
+	Gain      *float64 `json:"gain"`
+	Offset    float64  `json:"offset"`
+}
+
+type calibrationResponse struct {
+	apiResponse
+	Calibrations []kb.Calibration `json:"calibrations"`
+}
+
+// CalibrationHandler adds, lists and deletes the calibrations of the
+// caller's sensors.
+func CalibrationHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		calibrationPost(w, r, k)
+	} else if r.Method == "GET" {
+		calibrationGet(w, r, k)
+	} else if r.Method == "DELETE" {
+		calibrationDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func calibrationGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	cr := new(calibrationResponse)
+	cr.Token = newToken
+	cr.Success = true
+	cr.Calibrations = k.ListCalibrations(user, r.URL.Query().Get("sensor"))
+	if cr.Calibrations == nil {
+		cr.Calibrations = []kb.Calibration{}
+	}
+	payload, _ := json.Marshal(cr)
+	w.Write(payload)
+}
+
+// calibrationPost adds a calibration taking effect at effective. The gain
+// defaults to 1, so only an offset need be given.
+func calibrationPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return
+	}
+
+	rec := new(calibrationBody)
+	if json.Unmarshal(body, rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	cal := kb.Calibration{Owner: user, Sensor: rec.Sensor, Effective: rec.Effective, Gain: 1, Offset: rec.Offset}
+	if rec.Gain != nil {
+		cal.Gain = *rec.Gain
+	}
+	if cal.Sensor == "" || cal.Gain == 0 {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad calibration for sensor '%s' with gain %f\n", cal.Sensor, cal.Gain)
+		return
+	}
+	if cal.ID, ok = k.AddCalibration(cal); !ok {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not add calibration for sensor '%s' at %f\n", cal.Sensor, cal.Effective)
+		return
+	}
+
+	cr := new(calibrationResponse)
+	cr.Token = newToken
+	cr.Success = true
+	cr.Calibrations = []kb.Calibration{cal}
+	payload, _ := json.Marshal(cr)
+	w.Write(payload)
+}
+
+func calibrationDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || !k.DeleteCalibration(user, id) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("calibration '%s' not found for user '%s'\n", r.URL.Query().Get("id"), user)
+		return
+	}
+
+	success := apiResponse{true, newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// calibrate applies to each reading the calibration in effect when it was
+// taken. cals must be sorted by Effective. Readings from before the first
+// calibration are only shifted by the sensor's calibration offset.
+func calibrate(values map[float64]float64, cals []kb.Calibration, offset float64) map[float64]float64 {
+	calibrated := make(map[float64]float64, len(values))
+	for ts, value := range values {
+		i := sort.Search(len(cals), func(i int) bool { return cals[i].Effective > ts })
+		if i == 0 {
+			calibrated[ts] = value + offset
+		} else {
+			calibrated[ts] = cals[i-1].Apply(value)
+		}
+	}
+	return calibrated
+}
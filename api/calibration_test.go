@@ -0,0 +1,50 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+func TestCalibrate(t *testing.T) {
+	cals := []kb.Calibration{
+		{Effective: 100, Gain: 1, Offset: 0.5},
+		{Effective: 200, Gain: 2, Offset: -1},
+	}
+	tests := []struct {
+		name   string
+		cals   []kb.Calibration
+		offset float64
+		ts     float64
+		value  float64
+		want   float64
+	}{
+		{"no calibrations", nil, 0, 50, 20, 20},
+		{"sensor offset only", nil, 0.25, 50, 20, 20.25},
+		{"before first", cals, 0.25, 99.5, 20, 20.25},
+		{"at first", cals, 0.25, 100, 20, 20.5},
+		{"between", cals, 0.25, 150, 20, 20.5},
+		{"at second", cals, 0.25, 200, 20, 39},
+		{"after last", cals, 0.25, 1e9, 10, 19},
+	}
+	for _, tt := range tests {
+		got := calibrate(map[float64]float64{tt.ts: tt.value}, tt.cals, tt.offset)
+		if len(got) != 1 || got[tt.ts] != tt.want {
+			t.Errorf("%s: calibrate at %v = %v, want %v", tt.name, tt.ts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	s "strings"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// Uploads compress well, so a small body may inflate to a huge one. Bodies
+// read whole are cut off at maxInflatedBytes once uncompressed; bulk
+// uploads are streamed and have no limit.
+const maxInflatedBytes = 64 << 20
+
+// Content types of the binary encodings /api/temp accepts besides JSON.
+const (
+	contentCBOR     = "application/cbor"
+	contentProtobuf = "application/x-protobuf"
+)
+
+// temperatureUpload is a POST to /api/temp in any encoding, reduced to its
+// token, sensor and points.
+type temperatureUpload struct {
+	Token  string
+	Sensor string
+	Points []pointBody
+}
+
+type temperatureCBORBody struct {
+	Token     string            `cbor:"token"`
+	Sensor    string            `cbor:"sensor"`
+	Value     *float64          `cbor:"value"`
+	Timestamp *float64          `cbor:"timestamp"`
+	Points    []cbor.RawMessage `cbor:"points"`
+}
+
+// upload handles an upload to any endpoint with handle, once its body is
+// uncompressed, answering retries through idempotent.
+func upload(w http.ResponseWriter, r *http.Request, k kb.KB, handle func(w http.ResponseWriter)) {
+	if !uncompressBody(w, r) {
+		return
+	}
+	idempotent(w, r, k, handle)
+}
+
+// uncompressBody replaces the body of a request sent with a gzip or
+// deflate Content-Encoding by what it uncompresses to. Deflate is meant to
+// come in a zlib wrapper, but some clients send it raw, so both are read.
+// It answers the request itself if the body can't be uncompressed.
+func uncompressBody(w http.ResponseWriter, r *http.Request) bool {
+	encoding := s.ToLower(s.TrimSpace(r.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	var err error
+	switch encoding {
+	case "", "identity":
+		return true
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(r.Body)
+	case "deflate":
+		br := bufio.NewReader(r.Body)
+		if hdr, _ := br.Peek(2); len(hdr) == 2 && hdr[0]&0x0f == 8 && (uint(hdr[0])<<8|uint(hdr[1]))%31 == 0 {
+			body, err = zlib.NewReader(br)
+		} else {
+			body = flate.NewReader(br)
+		}
+	default:
+		requestFailed(w, http.StatusUnsupportedMediaType)
+		log.Printf("unsupported content encoding '%s'\n", encoding)
+		return false
+	}
+	if err != nil {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad %s request body: %v\n", encoding, err)
+		return false
+	}
+
+	if contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); !isBulkUpload(contentType) {
+		body = http.MaxBytesReader(w, body, maxInflatedBytes)
+	}
+	r.Body = body
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return true
+}
+
+func uploadFormat(contentType string) string {
+	switch contentType {
+	case contentCBOR:
+		return "cbor"
+	case contentProtobuf, "application/protobuf":
+		return "protobuf"
+	}
+	return "json"
+}
+
+func isBinaryUpload(contentType string) bool {
+	return contentType == contentCBOR || contentType == contentProtobuf || contentType == "application/protobuf"
+}
+
+// decodeTemperatureUpload reads a body of the given content type, JSON
+// unless it is one of the binary encodings. A single value and timestamp
+// are taken as a list of one point.
+func decodeTemperatureUpload(contentType string, body []byte) (temperatureUpload, error) {
+	switch contentType {
+	case contentCBOR:
+		return decodeCBORUpload(body)
+	case contentProtobuf, "application/protobuf":
+		return decodeProtobufUpload(body)
+	}
+	return decodeJSONUpload(body)
+}
+
+func decodeJSONUpload(body []byte) (temperatureUpload, error) {
+	rec := temperaturePostBody{}
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return temperatureUpload{}, err
+	}
+	up := temperatureUpload{rec.Token, rec.Sensor, make([]pointBody, len(rec.Points))}
+	for i, raw := range rec.Points {
+		if json.Unmarshal(raw, &up.Points[i]) != nil {
+			up.Points[i] = pointBody{}
+		}
+	}
+	if len(up.Points) == 0 {
+		up.Points = []pointBody{{rec.Timestamp, rec.Value}}
+	}
+	return up, nil
+}
+
+// decodeCBORUpload reads a CBOR map with the same keys as the JSON body.
+func decodeCBORUpload(body []byte) (temperatureUpload, error) {
+	rec := temperatureCBORBody{}
+	if err := cbor.Unmarshal(body, &rec); err != nil {
+		return temperatureUpload{}, err
+	}
+	up := temperatureUpload{rec.Token, rec.Sensor, make([]pointBody, len(rec.Points))}
+	for i, raw := range rec.Points {
+		var pt struct {
+			Timestamp *float64 `cbor:"timestamp"`
+			Value     *float64 `cbor:"value"`
+		}
+		if cbor.Unmarshal(raw, &pt) == nil {
+			up.Points[i] = pointBody{pt.Timestamp, pt.Value}
+		}
+	}
+	if len(up.Points) == 0 {
+		up.Points = []pointBody{{rec.Timestamp, rec.Value}}
+	}
+	return up, nil
+}
@@ -0,0 +1,476 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type householdBody struct {
+	Token      string `json:"token"`
+	Household  int64  `json:"household"`
+	Name       string `json:"name"`
+	User       string `json:"user"`
+	Permission string `json:"permission"`
+	Sensor     string `json:"sensor"`
+	Location   int64  `json:"location"`
+}
+
+type householdResponse struct {
+	apiResponse
+	Households []kb.Household       `json:"households,omitempty"`
+	Members    []kb.HouseholdMember `json:"members,omitempty"`
+	Sensors    []kb.HouseholdSensor `json:"sensors,omitempty"`
+	Locations  []kb.Location        `json:"locations,omitempty"`
+}
+
+// HouseholdHandler creates, lists and deletes households.
+func HouseholdHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		householdPost(w, r, k)
+	} else if r.Method == "GET" {
+		householdGet(w, r, k)
+	} else if r.Method == "DELETE" {
+		householdDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+// HouseholdMemberHandler adds, changes and removes household members.
+func HouseholdMemberHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "PUT" {
+		householdMemberPut(w, r, k)
+	} else if r.Method == "DELETE" {
+		householdMemberDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+// HouseholdSensorHandler shares sensors with a household or stops sharing
+// them.
+func HouseholdSensorHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		householdSensorPost(w, r, k)
+	} else if r.Method == "DELETE" {
+		householdSensorDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+// HouseholdLocationHandler shares locations with a household or stops
+// sharing them.
+func HouseholdLocationHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		householdLocationPost(w, r, k)
+	} else if r.Method == "DELETE" {
+		householdLocationDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func householdPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readHouseholdBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if rec.Name == "" {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("missing household name\n")
+		return
+	}
+	id, ok := k.AddHousehold(user, rec.Name)
+	if !ok {
+		requestFailedToken(w, http.StatusInternalServerError, newToken)
+		log.Printf("could not add household '%s' for user '%s'\n", rec.Name, user)
+		return
+	}
+
+	hr := new(householdResponse)
+	hr.Token = newToken
+	hr.Success = true
+	household, _ := k.GetHousehold(id)
+	household.Permission = kb.HouseholdAdmin
+	hr.Households = []kb.Household{household}
+	payload, _ := json.Marshal(hr)
+	w.Write(payload)
+}
+
+// householdGet lists the caller's households, or with household=id shows
+// one of them with its members, shared sensors and locations.
+func householdGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	hr := new(householdResponse)
+	hr.Token = newToken
+	hr.Success = true
+	if params.Get("household") == "" {
+		hr.Households = k.ListHouseholds(user)
+		payload, _ := json.Marshal(hr)
+		w.Write(payload)
+		return
+	}
+
+	id, ok := householdParam(w, params.Get("household"), newToken)
+	if !ok {
+		return
+	}
+	perm, ok := householdMember(w, id, user, kb.HouseholdRead, newToken, k)
+	if !ok {
+		return
+	}
+	household, ok := k.GetHousehold(id)
+	if !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("household %d not found\n", id)
+		return
+	}
+	household.Permission = perm
+	hr.Households = []kb.Household{household}
+	hr.Members = k.ListMembers(id)
+	hr.Sensors = k.ListHouseholdSensors(id)
+	for _, loc := range k.ListLocations(user) {
+		if loc.Household == id {
+			hr.Locations = append(hr.Locations, loc)
+		}
+	}
+	payload, _ := json.Marshal(hr)
+	w.Write(payload)
+}
+
+func householdDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	id, ok := householdParam(w, params.Get("household"), newToken)
+	if !ok {
+		return
+	}
+	if _, ok = householdMember(w, id, user, kb.HouseholdAdmin, newToken, k); !ok {
+		return
+	}
+
+	success := apiResponse{k.DeleteHousehold(id), newToken}
+	if success.Success {
+		audit(r, k, user, kb.AuditDelete, user, fmt.Sprintf("household %d", id))
+	}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// householdMemberPut adds a user to a household or changes their
+// permission. Only household admins may do this.
+func householdMemberPut(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readHouseholdBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if _, ok = householdMember(w, rec.Household, user, kb.HouseholdAdmin, newToken, k); !ok {
+		return
+	}
+	if !kb.ValidHouseholdPermission(rec.Permission) {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad household permission '%s'\n", rec.Permission)
+		return
+	}
+	if _, ok = k.GetAccount(rec.User); !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("user '%s' not found\n", rec.User)
+		return
+	}
+	if rec.Permission != kb.HouseholdAdmin && lastHouseholdAdmin(rec.Household, rec.User, k) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("user '%s' is the last admin of household %d\n", rec.User, rec.Household)
+		return
+	}
+
+	hr := new(householdResponse)
+	hr.Token = newToken
+	hr.Success = k.SetMember(rec.Household, rec.User, rec.Permission)
+	hr.Members = k.ListMembers(rec.Household)
+	payload, _ := json.Marshal(hr)
+	w.Write(payload)
+}
+
+// householdMemberDelete removes a member, which household admins may do to
+// anyone and every member may do to themselves.
+func householdMemberDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	id, ok := householdParam(w, params.Get("household"), newToken)
+	if !ok {
+		return
+	}
+	member := params.Get("user")
+	if member == "" {
+		member = user
+	}
+	want := kb.HouseholdAdmin
+	if member == user {
+		want = kb.HouseholdRead
+	}
+	if _, ok = householdMember(w, id, user, want, newToken, k); !ok {
+		return
+	}
+	if lastHouseholdAdmin(id, member, k) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("user '%s' is the last admin of household %d\n", member, id)
+		return
+	}
+
+	success := apiResponse{k.RemoveMember(id, member), newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// householdSensorPost shares one of the caller's sensors with a household.
+func householdSensorPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readHouseholdBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if _, ok = householdMember(w, rec.Household, user, kb.HouseholdManage, newToken, k); !ok {
+		return
+	}
+	if rec.Sensor == "" {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("missing sensor\n")
+		return
+	}
+
+	hr := new(householdResponse)
+	hr.Token = newToken
+	hr.Success = k.AddHouseholdSensor(rec.Household, user, rec.Sensor)
+	hr.Sensors = k.ListHouseholdSensors(rec.Household)
+	payload, _ := json.Marshal(hr)
+	w.Write(payload)
+}
+
+// householdSensorDelete stops sharing a sensor. Managers may unshare their
+// own sensors, admins anybody's.
+func householdSensorDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	id, ok := householdParam(w, params.Get("household"), newToken)
+	if !ok {
+		return
+	}
+	owner := params.Get("user")
+	if owner == "" {
+		owner = user
+	}
+	want := kb.HouseholdAdmin
+	if owner == user {
+		want = kb.HouseholdManage
+	}
+	if _, ok = householdMember(w, id, user, want, newToken, k); !ok {
+		return
+	}
+
+	if !k.RemoveHouseholdSensor(id, owner, params.Get("sensor")) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("sensor '%s' of user '%s' not shared with household %d\n", params.Get("sensor"), owner, id)
+		return
+	}
+	success := apiResponse{true, newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// householdLocationPost shares one of the caller's locations with a
+// household. A location belongs to at most one household.
+func householdLocationPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readHouseholdBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if _, ok = householdMember(w, rec.Household, user, kb.HouseholdManage, newToken, k); !ok {
+		return
+	}
+	if loc, ok := k.GetLocation(user, rec.Location); !ok || loc.Owner != user {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("location %d not found for user '%s'\n", rec.Location, user)
+		return
+	}
+
+	success := apiResponse{k.SetLocationHousehold(user, rec.Location, rec.Household), newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// householdLocationDelete stops sharing a location. Managers may unshare
+// their own locations, admins anybody's.
+func householdLocationDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	id, ok := householdParam(w, params.Get("household"), newToken)
+	if !ok {
+		return
+	}
+	perm, ok := householdMember(w, id, user, kb.HouseholdManage, newToken, k)
+	if !ok {
+		return
+	}
+	l_id, err := strconv.ParseInt(params.Get("location"), 10, 64)
+	if err != nil {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad location id %s\n", params.Get("location"))
+		return
+	}
+	loc, ok := k.GetLocation(user, l_id)
+	if !ok || loc.Household != id || (loc.Owner != user && perm != kb.HouseholdAdmin) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("location %d not shared by user '%s' with household %d\n", l_id, user, id)
+		return
+	}
+
+	success := apiResponse{k.SetLocationHousehold(loc.Owner, l_id, 0), newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+func readHouseholdBody(w http.ResponseWriter, r *http.Request) (*householdBody, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return nil, false
+	}
+
+	rec := new(householdBody)
+	if json.Unmarshal(body, rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return nil, false
+	}
+	return rec, true
+}
+
+func householdParam(w http.ResponseWriter, param, newToken string) (int64, bool) {
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad household id %s\n", param)
+		return 0, false
+	}
+	return id, true
+}
+
+// householdMember looks up the user's permission in a household, answering
+// the request itself when they are not a member or may not do what needs
+// the permission want.
+func householdMember(w http.ResponseWriter, id int64, user, want, newToken string, k kb.KB) (string, bool) {
+	perm, ok := k.GetMembership(id, user)
+	if !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("household %d not found for user '%s'\n", id, user)
+		return "", false
+	}
+	if !kb.HouseholdAllows(perm, want) {
+		requestFailedToken(w, http.StatusForbidden, newToken)
+		log.Printf("user '%s' lacks %s permission in household %d\n", user, want, id)
+		return "", false
+	}
+	return perm, true
+}
+
+// lastHouseholdAdmin reports whether user is the only admin left in a
+// household, who may not leave or step down.
+func lastHouseholdAdmin(id int64, user string, k kb.KB) bool {
+	admins := 0
+	isAdmin := false
+	for _, m := range k.ListMembers(id) {
+		if m.Permission == kb.HouseholdAdmin {
+			admins++
+			isAdmin = isAdmin || m.User == user
+		}
+	}
+	return isAdmin && admins == 1
+}
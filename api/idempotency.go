@@ -0,0 +1,191 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+
+	s "strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+)
+
+const maxIdempotencyKey = 255
+
+// maxTokenPeek bounds how much of a JSON body is read ahead of the handler
+// to find the token in it.
+const maxTokenPeek = 16 << 20
+
+// idempotent handles an upload with handle, unless it carries an
+// idempotency key already used with the same token. Then the answer to
+// the first attempt is sent again, new token and all, so a device that
+// lost the answer can retry even though its token has since been rotated.
+// Only answers that used up the token or stored data are kept; after any
+// other the upload is handled afresh on retry, as it is once the first
+// attempt has gone idempotencylease seconds without answering.
+func idempotent(w http.ResponseWriter, r *http.Request, k kb.KB, handle func(w http.ResponseWriter)) {
+	key := r.Header.Get(idempotencyHeader)
+	if key == "" {
+		handle(w)
+		return
+	}
+	if len(key) > maxIdempotencyKey {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("idempotency key too long\n")
+		return
+	}
+	token := requestToken(r, peekToken(r))
+	if token == "" {
+		handle(w)
+		return
+	}
+
+	hash := hashToken(token)
+	now := time.Now().Unix()
+	upload, found := k.GetUpload(hash, key)
+	if found && upload.Expires < now {
+		k.DeleteUpload(hash, key)
+		found = false
+	}
+	if found && (upload.Status != 0 || now < upload.Started+viper.GetInt64("idempotencylease")) {
+		replayUpload(w, upload)
+		return
+	}
+	user, ok := uploadUser(token, k)
+	if !ok {
+		handle(w)
+		return
+	}
+
+	// A claim still in progress after its lease belongs to an attempt that
+	// died before answering, and the retry takes it over.
+	claim := kb.Upload{Key: key, TokenHash: hash, User: user, Expires: now + idempotencyWindow(), Started: now}
+	if found {
+		ok = k.TakeOverUpload(claim, upload.Started)
+	} else {
+		ok = k.AddUpload(claim)
+	}
+	if !ok {
+		upload, _ = k.GetUpload(hash, key)
+		replayUpload(w, upload)
+		return
+	}
+
+	rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+	handle(rec)
+	newToken := rec.Header().Get(tokenHeader)
+	if newToken != "" || rec.status == http.StatusOK {
+		k.FinishUpload(kb.Upload{Key: key, TokenHash: hash, Started: now, Status: int64(rec.status), NewToken: newToken, Response: rec.body.String()})
+	} else {
+		k.DeleteUpload(hash, key)
+	}
+}
+
+// idempotencyWindow is how long answers are kept. A retry can't come after
+// its token has expired, so they are kept no longer than that.
+func idempotencyWindow() int64 {
+	window := viper.GetInt64("idempotencywindow")
+	if exp := viper.GetInt64("exptoken"); exp < window {
+		window = exp
+	}
+	return window
+}
+
+// replayUpload sends the kept answer to an upload, or a conflict while the
+// first attempt is still being handled.
+func replayUpload(w http.ResponseWriter, upload kb.Upload) {
+	if upload.Status == 0 {
+		requestFailed(w, http.StatusConflict)
+		log.Printf("upload '%s' still in progress\n", upload.Key)
+		return
+	}
+	if upload.NewToken != "" {
+		w.Header().Set(tokenHeader, upload.NewToken)
+	}
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(int(upload.Status))
+	w.Write([]byte(upload.Response))
+}
+
+// uploadUser finds whose token of any kind this is, without rotating it.
+func uploadUser(token string, k kb.KB) (string, bool) {
+	if isAPIKey(token) {
+		user, _, ok := checkAPIKey(token, k)
+		return user, ok
+	}
+	return tokenUser(token, k)
+}
+
+// peekToken returns the token in a JSON body, leaving the body for the
+// handler to read as if it hadn't been touched. Bulk and image uploads
+// carry no token in their body and are not read; CBOR and protobuf ones
+// are decoded like a JSON one.
+func peekToken(r *http.Request) string {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if isBulkUpload(contentType) || s.HasPrefix(contentType, "image/") {
+		return ""
+	}
+	peeked, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxTokenPeek))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(peeked), r.Body))
+	if isBinaryUpload(contentType) {
+		rec, _ := decodeTemperatureUpload(contentType, peeked)
+		return rec.Token
+	}
+
+	var rec struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(peeked, &rec)
+	return rec.Token
+}
+
+// hashToken keeps tokens out of the uploads table; a hash is enough to
+// match a retry to its first attempt.
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// recordingWriter keeps a copy of the answer it writes.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
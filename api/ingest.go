@@ -0,0 +1,169 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// Reasons an uploaded point is rejected for.
+const (
+	rejectDuplicate  = "duplicate"
+	rejectOutOfRange = "out_of_range"
+	rejectFuture     = "future"
+	rejectTooOld     = "too_old"
+	rejectMalformed  = "malformed"
+)
+
+type rejectedPoint struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// ingestResponse counts the points stored and those already there, and
+// lists every point rejected. A point that passed the checks but was
+// stored by another upload first counts as a duplicate without an entry
+// in Rejected.
+type ingestResponse struct {
+	apiResponse
+	Accepted  int64           `json:"accepted"`
+	Duplicate int64           `json:"duplicate"`
+	Rejected  []rejectedPoint `json:"rejected"`
+}
+
+// stored records that added of the tried points that passed the checks
+// were new.
+func (ir *ingestResponse) stored(tried int, added int64) {
+	ir.Accepted = added
+	ir.Duplicate = int64(tried) - added
+	for _, rp := range ir.Rejected {
+		if rp.Reason == rejectDuplicate {
+			ir.Duplicate++
+		}
+	}
+}
+
+// pointBody is an uploaded point before it is known to be well formed. A
+// point that can't be decoded at all is left empty.
+type pointBody struct {
+	Timestamp *float64 `json:"timestamp"`
+	Value     *float64 `json:"value"`
+}
+
+// readingRules are the server's idea of a plausible temperature reading:
+// a value between min and max, taken no more than maxFuture seconds ahead
+// of the server's clock and, unless maxAge is 0, no more than maxAge
+// seconds ago.
+type readingRules struct {
+	min, max          float64
+	maxFuture, maxAge float64
+	now               float64
+}
+
+func temperatureRules() readingRules {
+	return readingRules{
+		min:       viper.GetFloat64("mintemperature"),
+		max:       viper.GetFloat64("maxtemperature"),
+		maxFuture: viper.GetFloat64("maxfuture"),
+		maxAge:    viper.GetFloat64("maxage"),
+		now:       float64(time.Now().UnixNano()) / 1e9,
+	}
+}
+
+// measurementRules are the rules for a measurement of kind. Only
+// temperatures have a plausible range.
+func measurementRules(kind string) readingRules {
+	rules := temperatureRules()
+	if kind != kb.KindTemperature && kind != kb.KindSurfaceTemperature {
+		rules.min, rules.max = math.Inf(-1), math.Inf(1)
+	}
+	return rules
+}
+
+// check returns why the reading is implausible, or "" if it is not.
+func (rules readingRules) check(r kb.Reading) string {
+	switch {
+	case r.Timestamp <= 0 || math.IsNaN(r.Timestamp) || math.IsInf(r.Timestamp, 0) ||
+		math.IsNaN(r.Value) || math.IsInf(r.Value, 0):
+		return rejectMalformed
+	case r.Value < rules.min || r.Value > rules.max:
+		return rejectOutOfRange
+	case r.Timestamp > rules.now+rules.maxFuture:
+		return rejectFuture
+	case rules.maxAge > 0 && r.Timestamp < rules.now-rules.maxAge:
+		return rejectTooOld
+	}
+	return ""
+}
+
+// checkReadings sorts the points of a temperature upload for sensor into
+// the readings to store and those rejected, by their index in the upload.
+func checkReadings(user, sensor string, points []pointBody, k kb.KB) ([]kb.Reading, []rejectedPoint) {
+	return checkPoints(user, sensor, kb.KindTemperature, temperatureRules(), points, k)
+}
+
+// checkPoints does the same for points of any kind. Readings the user
+// already has, or that come twice in the upload, are duplicates.
+func checkPoints(user, sensor, kind string, rules readingRules, points []pointBody, k kb.KB) ([]kb.Reading, []rejectedPoint) {
+	readings := make([]kb.Reading, 0, len(points))
+	indices := make([]int, 0, len(points))
+	rejected := make([]rejectedPoint, 0)
+	start, end := math.Inf(1), math.Inf(-1)
+	for i, pt := range points {
+		if pt.Timestamp == nil || pt.Value == nil {
+			rejected = append(rejected, rejectedPoint{i, rejectMalformed})
+			continue
+		}
+		r := kb.Reading{Timestamp: *pt.Timestamp, Value: *pt.Value}
+		if reason := rules.check(r); reason != "" {
+			rejected = append(rejected, rejectedPoint{i, reason})
+			continue
+		}
+		readings = append(readings, r)
+		indices = append(indices, i)
+		start, end = math.Min(start, r.Timestamp), math.Max(end, r.Timestamp)
+	}
+	if len(readings) == 0 {
+		return readings, rejected
+	}
+
+	seen := make(map[float64]bool)
+	for _, m := range k.GetMeasurements(user, sensor, kind, start, end) {
+		if m.Owner == user {
+			seen[m.Timestamp] = true
+		}
+	}
+	fresh := readings[:0]
+	for j, r := range readings {
+		if seen[r.Timestamp] {
+			rejected = append(rejected, rejectedPoint{indices[j], rejectDuplicate})
+			continue
+		}
+		seen[r.Timestamp] = true
+		fresh = append(fresh, r)
+	}
+	sortRejected(rejected)
+	return fresh, rejected
+}
+
+func sortRejected(rejected []rejectedPoint) {
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i].Index < rejected[j].Index })
+}
@@ -0,0 +1,235 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// locationBody leaves out coordinates that weren't sent, so that 0 is
+// never taken for one.
+type locationBody struct {
+	Token     string   `json:"token"`
+	ID        int64    `json:"id"`
+	PlaceName string   `json:"place_name"`
+	Lat       *float64 `json:"lat"`
+	Lon       *float64 `json:"lon"`
+}
+
+type locationResponse struct {
+	apiResponse
+	Locations []kb.Location `json:"locations"`
+}
+
+func LocationHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		locationPost(w, r, k)
+	} else if r.Method == "GET" {
+		locationGet(w, r, k)
+	} else if r.Method == "PUT" {
+		locationPut(w, r, k)
+	} else if r.Method == "DELETE" {
+		locationDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func locationGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	lr := new(locationResponse)
+	lr.Token = newToken
+	lr.Success = true
+	if id := params.Get("id"); id != "" {
+		l_id, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			requestFailedToken(w, http.StatusBadRequest, newToken)
+			log.Printf("bad location id %s\n", id)
+			return
+		}
+		loc, ok := k.GetLocation(user, l_id)
+		if !ok {
+			requestFailedToken(w, http.StatusNotFound, newToken)
+			log.Printf("location %d not found for user '%s'\n", l_id, user)
+			return
+		}
+		lr.Locations = []kb.Location{loc}
+	} else {
+		lr.Locations = k.ListLocations(user)
+		if lr.Locations == nil {
+			lr.Locations = []kb.Location{}
+		}
+	}
+	payload, _ := json.Marshal(lr)
+	w.Write(payload)
+}
+
+func locationPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readLocationBody(w, r, true)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	lat, lon := formatCoordinate(*rec.Lat), formatCoordinate(*rec.Lon)
+	id, ok := k.AddLocation(user, rec.PlaceName, lat, lon)
+	if !ok {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not add location '%s' for user '%s'\n", rec.PlaceName, user)
+		return
+	}
+
+	lr := new(locationResponse)
+	lr.Token = newToken
+	lr.Success = true
+	lr.Locations = []kb.Location{{ID: id, Owner: user, PlaceName: rec.PlaceName, Lat: lat, Lon: lon}}
+	payload, _ := json.Marshal(lr)
+	w.Write(payload)
+}
+
+// locationPut changes the fields of a location that are sent and keeps the
+// rest.
+func locationPut(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readLocationBody(w, r, false)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	loc, ok := k.GetLocation(user, rec.ID)
+	if !ok || loc.Owner != user {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("location %d not found for user '%s'\n", rec.ID, user)
+		return
+	}
+
+	name, lat, lon := loc.PlaceName, loc.Lat, loc.Lon
+	if rec.PlaceName != "" {
+		name = rec.PlaceName
+	}
+	if rec.Lat != nil {
+		lat = formatCoordinate(*rec.Lat)
+	}
+	if rec.Lon != nil {
+		lon = formatCoordinate(*rec.Lon)
+	}
+	if !k.UpdateLocation(user, rec.ID, name, lat, lon) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not update location %d for user '%s'\n", rec.ID, user)
+		return
+	}
+
+	lr := new(locationResponse)
+	lr.Token = newToken
+	lr.Success = true
+	lr.Locations = []kb.Location{{ID: rec.ID, Owner: user, Household: loc.Household, PlaceName: name, Lat: lat, Lon: lon}}
+	payload, _ := json.Marshal(lr)
+	w.Write(payload)
+}
+
+func locationDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	id, err := strconv.ParseInt(params.Get("id"), 10, 64)
+	if err != nil {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad location id %s\n", params.Get("id"))
+		return
+	}
+	if loc, ok := k.GetLocation(user, id); !ok || loc.Owner != user {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("location %d not found for user '%s'\n", id, user)
+		return
+	}
+
+	success := apiResponse{k.DeleteLocation(user, id), newToken}
+	if success.Success {
+		audit(r, k, user, kb.AuditDelete, user, fmt.Sprintf("location %d and its weather", id))
+	}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// readLocationBody parses and validates a POST or PUT body, answering the
+// request itself when the body is unusable. A complete body must have a
+// place name and both coordinates.
+func readLocationBody(w http.ResponseWriter, r *http.Request, complete bool) (*locationBody, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return nil, false
+	}
+
+	rec := new(locationBody)
+	if json.Unmarshal(body, rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return nil, false
+	}
+
+	if complete && (rec.PlaceName == "" || rec.Lat == nil || rec.Lon == nil) ||
+		!coordinateOK(rec.Lat, 90) || !coordinateOK(rec.Lon, 180) {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad or incomplete location '%s'\n", rec.PlaceName)
+		return nil, false
+	}
+	return rec, true
+}
+
+// coordinateOK tells whether a coordinate, if given, is within limit
+// degrees either way.
+func coordinateOK(c *float64, limit float64) bool {
+	return c == nil || *c >= -limit && *c <= limit
+}
+
+// formatCoordinate renders a coordinate to fit the 12 character lat and lon
+// columns; six decimal places is well under a meter.
+func formatCoordinate(c float64) string {
+	return strconv.FormatFloat(c, 'f', 6, 64)
+}
@@ -0,0 +1,118 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elithrar/simple-scrypt"
+	"github.com/spf13/viper"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// failureMu serializes the read-modify-write of failure counters so that
+// concurrent guesses are all counted.
+var failureMu sync.Mutex
+
+var (
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+// lockedFor returns how many seconds are left on the lockout of a user name
+// or client address, or 0 when it may try to log in.
+func lockedFor(kind, subject string, k kb.KB) int64 {
+	if subject == "" {
+		return 0
+	}
+	f, ok := k.GetLoginFailure(kind, subject)
+	if !ok {
+		return 0
+	}
+	left := f.LockedUntil - time.Now().Unix()
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// lockoutWait returns how many seconds a user, logging in from ip, must
+// wait: the longer of the lockouts of the user name and the address.
+func lockoutWait(user, ip string, k kb.KB) int64 {
+	wait := lockedFor(kb.FailureUser, user, k)
+	if ipWait := lockedFor(kb.FailureIP, ip, k); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+// recordFailure counts a failed login. Once a subject reaches its threshold
+// every further failure locks it out for twice as long as the last one, up
+// to lockoutmaxdelay. Failures older than lockoutwindow are forgotten.
+func recordFailure(kind, subject string, k kb.KB) {
+	threshold := viper.GetInt64("lockoutthreshold")
+	if kind == kb.FailureIP {
+		threshold = viper.GetInt64("lockoutipthreshold")
+	}
+	if subject == "" || threshold <= 0 {
+		return
+	}
+
+	failureMu.Lock()
+	defer failureMu.Unlock()
+
+	now := time.Now().Unix()
+	f, _ := k.GetLoginFailure(kind, subject)
+	if now-f.LastFailure > viper.GetInt64("lockoutwindow") && f.LockedUntil < now {
+		f.Failures = 0
+	}
+	f.Failures++
+	f.LastFailure = now
+	if f.Failures >= threshold {
+		f.LockedUntil = now + backoff(f.Failures-threshold)
+	}
+	k.SetLoginFailure(f)
+}
+
+// backoff returns the lockout in seconds after n failures past the threshold.
+func backoff(n int64) int64 {
+	delay := viper.GetInt64("lockoutdelay")
+	max := viper.GetInt64("lockoutmaxdelay")
+	if delay <= 0 {
+		delay = 1
+	}
+	for ; n > 0 && delay < max; n-- {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// comparePassword checks a password against a stored hash. When the user
+// does not exist the password is checked against a throwaway hash instead,
+// so unknown users take as long to reject as wrong passwords.
+func comparePassword(hash []byte, found bool, password string) bool {
+	if !found {
+		dummyOnce.Do(func() {
+			dummyHash, _ = scrypt.GenerateFromPassword([]byte("irleak"), ScryptParams())
+		})
+		scrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return scrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
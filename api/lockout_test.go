@@ -0,0 +1,45 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBackoff(t *testing.T) {
+	defer viper.Reset()
+	tests := []struct {
+		delay, max, n, want int64
+	}{
+		{1, 3600, 0, 1},
+		{1, 3600, 1, 2},
+		{1, 3600, 5, 32},
+		{1, 3600, 12, 3600},
+		{1, 3600, 1000, 3600},
+		{5, 60, 2, 20},
+		{5, 60, 4, 60},
+		{0, 3600, 3, 8},
+		{-2, 3600, 0, 1},
+	}
+	for _, tt := range tests {
+		viper.Set("lockoutdelay", tt.delay)
+		viper.Set("lockoutmaxdelay", tt.max)
+		if got := backoff(tt.n); got != tt.want {
+			t.Errorf("backoff(%d) with delay %d, max %d = %d, want %d", tt.n, tt.delay, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type measurementPostBody struct {
+	Token     string            `json:"token"`
+	Sensor    string            `json:"sensor"`
+	Kind      string            `json:"kind"`
+	Unit      string            `json:"unit"`
+	Value     *float64          `json:"value"`
+	Timestamp *float64          `json:"timestamp"`
+	Points    []json.RawMessage `json:"points"`
+}
+
+type measurementResponse struct {
+	apiResponse
+	Series []measurementSeries `json:"series"`
+}
+
+// measurementSeries holds the values of one kind from one sensor, keyed by
+// timestamp like the values of /api/temp. Household members' sensors of the
+// same name are told apart by their owner.
+type measurementSeries struct {
+	Owner  string              `json:"owner"`
+	Sensor string              `json:"sensor"`
+	Kind   string              `json:"kind"`
+	Unit   string              `json:"unit"`
+	Values map[float64]float64 `json:"values"`
+}
+
+// MeasurementHandler uploads and returns measurements of any kind.
+// Temperatures are measurements too, so /api/temp is the same data seen
+// through the temperature kind.
+func MeasurementHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		upload(w, r, k, func(w http.ResponseWriter) { measurementPost(w, r, k) })
+	} else if r.Method == "GET" {
+		measurementGet(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func measurementGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, allowed, ok := temperatureAuth(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	start_ts, end_ts, ok := timeRange(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad time range\n")
+		return
+	}
+	sensor := params.Get("sensor")
+	if sensor != "" && !sensorAllowed(allowed, sensor) {
+		requestFailedToken(w, http.StatusForbidden, newToken)
+		log.Printf("key not allowed sensor '%s'\n", sensor)
+		return
+	}
+
+	mr := new(measurementResponse)
+	mr.Token = newToken
+	mr.Success = true
+	mr.Series = make([]measurementSeries, 0)
+	var last *measurementSeries
+	for _, m := range k.GetMeasurements(user, sensor, params.Get("kind"), start_ts, end_ts) {
+		if !sensorAllowed(allowed, m.Sensor) {
+			continue
+		}
+		if last == nil || last.Owner != m.Owner || last.Sensor != m.Sensor || last.Kind != m.Kind || last.Unit != m.Unit {
+			mr.Series = append(mr.Series, measurementSeries{m.Owner, m.Sensor, m.Kind, m.Unit, make(map[float64]float64)})
+			last = &mr.Series[len(mr.Series)-1]
+		}
+		last.Values[m.Timestamp] = m.Value
+	}
+	payload, _ := json.Marshal(mr)
+	w.Write(payload)
+}
+
+// measurementPost stores a value, or the points, of one kind from one
+// sensor. Every kind is stored in its own unit, so any other unit is
+// refused rather than mixed into the series. Points are checked like those
+// of /api/temp, and those that pass go in with one commit.
+func measurementPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return
+	}
+
+	rec := measurementPostBody{}
+	if json.Unmarshal(body, &rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return
+	}
+
+	user, newToken, allowed, ok := temperatureAuth(w, r, rec.Token, permUpload, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	if !sensorAllowed(allowed, rec.Sensor) {
+		requestFailedToken(w, http.StatusForbidden, newToken)
+		log.Printf("key not allowed sensor '%s'\n", rec.Sensor)
+		return
+	}
+	unit, ok := kb.DefaultUnit(rec.Kind)
+	if !ok || rec.Sensor == "" {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad measurement of kind '%s' for sensor '%s'\n", rec.Kind, rec.Sensor)
+		return
+	}
+	if rec.Unit != "" && rec.Unit != unit {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("unit '%s' is not '%s' for kind '%s'\n", rec.Unit, unit, rec.Kind)
+		return
+	}
+
+	points := make([]pointBody, len(rec.Points))
+	for i, raw := range rec.Points {
+		if json.Unmarshal(raw, &points[i]) != nil {
+			points[i] = pointBody{}
+		}
+	}
+	if len(points) == 0 {
+		points = []pointBody{{rec.Timestamp, rec.Value}}
+	}
+	readings, rejected := checkPoints(user, rec.Sensor, rec.Kind, measurementRules(rec.Kind), points, k)
+	ms := make([]kb.Measurement, 0, len(readings))
+	for _, rd := range readings {
+		ms = append(ms, kb.Measurement{Owner: user, Sensor: rec.Sensor, Kind: rec.Kind, Unit: unit, Timestamp: rd.Timestamp, Value: rd.Value})
+	}
+	ok = true
+	var added int64
+	if len(ms) > 0 {
+		added, ok = k.AddMeasurements(ms)
+	}
+	ir := new(ingestResponse)
+	ir.Token = newToken
+	ir.Success = ok
+	ir.Rejected = rejected
+	if ok {
+		ir.stored(len(ms), added)
+	}
+	audit(r, k, user, kb.AuditUpload, user, fmt.Sprintf("%d %s points for sensor '%s', %d duplicate, %d rejected", ir.Accepted, rec.Kind, rec.Sensor, ir.Duplicate, len(rejected)))
+
+	payload, _ := json.Marshal(ir)
+	w.Write(payload)
+}
@@ -0,0 +1,167 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/elithrar/simple-scrypt"
+	"github.com/spf13/viper"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type passwordPutBody struct {
+	Token   string `json:"token"`
+	OldPass string `json:"old_password"`
+	NewPass string `json:"new_password"`
+}
+
+// ScryptParams returns the scrypt cost configured under scryptparams, or
+// the library defaults for anything left out or invalid.
+func ScryptParams() scrypt.Params {
+	p := scrypt.DefaultParams
+	conf := viper.GetStringMap("scryptparams")
+	if len(conf) == 0 {
+		return p
+	}
+	set := func(key string, field *int) {
+		if _, ok := conf[key]; ok {
+			*field = viper.GetInt("scryptparams." + key)
+		}
+	}
+	set("n", &p.N)
+	set("r", &p.R)
+	set("p", &p.P)
+	set("saltlen", &p.SaltLen)
+	set("dklen", &p.DKLen)
+	if err := p.Check(); err != nil {
+		log.Printf("bad scryptparams, using defaults: %v\n", err)
+		return scrypt.DefaultParams
+	}
+	return p
+}
+
+// HashPassword hashes a password with the configured scrypt cost.
+func HashPassword(password string) (string, error) {
+	hash, err := scrypt.GenerateFromPassword([]byte(password), ScryptParams())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// weakHash reports whether a stored hash was made with a lower cost than is
+// configured now.
+func weakHash(hash []byte) bool {
+	cur, err := scrypt.Cost(hash)
+	if err != nil {
+		return false
+	}
+	want := ScryptParams()
+	return cur.N < want.N || cur.R < want.R || cur.P < want.P || cur.SaltLen < want.SaltLen || cur.DKLen < want.DKLen
+}
+
+// upgradeHash re-hashes a password the user just proved with the current
+// cost if their stored hash is weaker.
+func upgradeHash(user, password string, hash []byte, k kb.KB) {
+	if !weakHash(hash) {
+		return
+	}
+	newHash, err := HashPassword(password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if k.SetHash(user, newHash) {
+		log.Printf("upgraded password hash of user '%s'\n", user)
+	}
+}
+
+func PasswordHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "PUT" {
+		passwordPut(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func passwordPut(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return
+	}
+
+	rec := passwordPutBody{}
+	if json.Unmarshal(body, &rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permSelf, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if rec.NewPass == "" {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("missing new password\n")
+		return
+	}
+
+	// The old password is guessed at here as at /api/auth, so the same
+	// failures count towards the same lockouts.
+	ip := clientIP(r)
+	if wait := lockoutWait(user, ip, k); wait > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(wait, 10))
+		requestFailedToken(w, http.StatusTooManyRequests, newToken)
+		log.Printf("password change for '%s' from %s locked out\n", user, ip)
+		audit(r, k, user, kb.AuditLoginFailed, user, "password change locked out")
+		return
+	}
+	hash, ok := k.GetHash(user)
+	if !ok || scrypt.CompareHashAndPassword(hash, []byte(rec.OldPass)) != nil {
+		recordFailure(kb.FailureUser, user, k)
+		recordFailure(kb.FailureIP, ip, k)
+		requestFailedToken(w, http.StatusForbidden, newToken)
+		log.Printf("bad old password for user '%s'\n", user)
+		audit(r, k, user, kb.AuditLoginFailed, user, "wrong old password")
+		return
+	}
+	k.ClearLoginFailure(kb.FailureUser, user)
+
+	newHash, err := HashPassword(rec.NewPass)
+	if err != nil {
+		requestFailedToken(w, http.StatusInternalServerError, newToken)
+		log.Println(err)
+		return
+	}
+
+	success := apiResponse{k.SetHash(user, newHash), newToken}
+	if success.Success {
+		audit(r, k, user, kb.AuditPassword, user, "")
+	}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
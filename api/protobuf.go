@@ -0,0 +1,113 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A series of temperatures is sent as protobuf in this schema:
+//
+//	message TemperatureUpload {
+//	  string token = 1;
+//	  string sensor = 2;
+//	  // Milliseconds since the epoch, each one the difference from the
+//	  // one before; the first is from 0.
+//	  repeated sint64 timestamps = 3 [packed = true];
+//	  // Values times scale, as differences like the timestamps.
+//	  repeated sint64 values = 4 [packed = true];
+//	  // Steps per degree, 100 if 0.
+//	  uint32 scale = 5;
+//	}
+//
+// Readings a few seconds and a fraction of a degree apart take two or
+// three bytes each this way.
+const defaultProtobufScale = 100
+
+var errBadProtobuf = errors.New("malformed protobuf")
+
+// decodeProtobufUpload reads a TemperatureUpload. Timestamps without a
+// value, or values without a timestamp, are malformed points.
+func decodeProtobufUpload(body []byte) (temperatureUpload, error) {
+	var up temperatureUpload
+	var times, values []int64
+	var scale uint64
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return up, errBadProtobuf
+		}
+		body = body[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			up.Token, n = protowire.ConsumeString(body)
+		case num == 2 && typ == protowire.BytesType:
+			up.Sensor, n = protowire.ConsumeString(body)
+		case num == 3:
+			times, n = consumeSint64s(times, typ, body)
+		case num == 4:
+			values, n = consumeSint64s(values, typ, body)
+		case num == 5 && typ == protowire.VarintType:
+			scale, n = protowire.ConsumeVarint(body)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, body)
+		}
+		if n < 0 {
+			return up, errBadProtobuf
+		}
+		body = body[n:]
+	}
+	if scale == 0 {
+		scale = defaultProtobufScale
+	}
+
+	count := len(times)
+	if len(values) > count {
+		count = len(values)
+	}
+	up.Points = make([]pointBody, count)
+	var t, v int64
+	for i := 0; i < len(times) && i < len(values); i++ {
+		t += times[i]
+		v += values[i]
+		ts, value := float64(t)/1000, float64(v)/float64(scale)
+		up.Points[i] = pointBody{&ts, &value}
+	}
+	return up, nil
+}
+
+// consumeSint64s appends the values of a repeated sint64 field, packed or
+// not, to list.
+func consumeSint64s(list []int64, typ protowire.Type, body []byte) ([]int64, int) {
+	if typ == protowire.VarintType {
+		x, n := protowire.ConsumeVarint(body)
+		return append(list, protowire.DecodeZigZag(x)), n
+	}
+	if typ != protowire.BytesType {
+		return list, -1
+	}
+	packed, n := protowire.ConsumeBytes(body)
+	for n >= 0 && len(packed) > 0 {
+		x, m := protowire.ConsumeVarint(packed)
+		if m < 0 {
+			return list, m
+		}
+		list = append(list, protowire.DecodeZigZag(x))
+		packed = packed[m:]
+	}
+	return list, n
+}
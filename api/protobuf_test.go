@@ -0,0 +1,129 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// series builds a TemperatureUpload from its fields. Deltas are packed
+// unless unpacked is set.
+func series(token, sensor string, times, values []int64, scale uint64, unpacked bool) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, token)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, sensor)
+	for _, field := range []struct {
+		num  protowire.Number
+		list []int64
+	}{{3, times}, {4, values}} {
+		if unpacked {
+			for _, x := range field.list {
+				b = protowire.AppendTag(b, field.num, protowire.VarintType)
+				b = protowire.AppendVarint(b, protowire.EncodeZigZag(x))
+			}
+			continue
+		}
+		var packed []byte
+		for _, x := range field.list {
+			packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(x))
+		}
+		b = protowire.AppendTag(b, field.num, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	if scale != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, scale)
+	}
+	return b
+}
+
+type point struct{ ts, value float64 }
+
+func TestDecodeProtobufUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want []point
+		bad  int // points without a timestamp or value, at the end
+	}{
+		{"empty series", series("t", "s", nil, nil, 0, false), nil, 0},
+		{"packed default scale", series("t", "s", []int64{1500000000000, 2000, -500}, []int64{2050, 5, -10}, 0, false),
+			[]point{{1500000000, 20.5}, {1500000002, 20.55}, {1500000001.5, 20.45}}, 0},
+		{"unpacked", series("t", "s", []int64{1000, 1000}, []int64{2000, 1}, 0, true),
+			[]point{{1, 20}, {2, 20.01}}, 0},
+		{"scale", series("t", "s", []int64{1000, 1000}, []int64{205, 1}, 10, false),
+			[]point{{1, 20.5}, {2, 20.6}}, 0},
+		{"negative values", series("t", "s", []int64{1000}, []int64{-1234}, 0, false),
+			[]point{{1, -12.34}}, 0},
+		{"more timestamps", series("t", "s", []int64{1000, 1000, 1000}, []int64{100}, 0, false),
+			[]point{{1, 1}}, 2},
+		{"more values", series("t", "s", []int64{1000}, []int64{100, 1}, 0, false),
+			[]point{{1, 1}}, 1},
+	}
+	for _, tt := range tests {
+		up, err := decodeProtobufUpload(tt.body)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if up.Token != "t" || up.Sensor != "s" {
+			t.Errorf("%s: token %q sensor %q", tt.name, up.Token, up.Sensor)
+		}
+		if len(up.Points) != len(tt.want)+tt.bad {
+			t.Errorf("%s: %d points, want %d", tt.name, len(up.Points), len(tt.want)+tt.bad)
+			continue
+		}
+		for i, pt := range up.Points {
+			if i >= len(tt.want) {
+				if pt.Timestamp != nil || pt.Value != nil {
+					t.Errorf("%s: point %d should be malformed", tt.name, i)
+				}
+				continue
+			}
+			if pt.Timestamp == nil || pt.Value == nil || !near(*pt.Timestamp, tt.want[i].ts) || !near(*pt.Value, tt.want[i].value) {
+				t.Errorf("%s: point %d = %v, want %v", tt.name, i, pt, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestDecodeProtobufUploadErrors(t *testing.T) {
+	good := series("t", "s", []int64{1000}, []int64{100}, 0, false)
+	for name, body := range map[string][]byte{
+		"bad tag":          {0xff, 0xff},
+		"truncated":        good[:len(good)-1],
+		"truncated deltas": append(protowire.AppendTag(nil, 3, protowire.BytesType), 5, 0x80),
+		"fixed32 deltas":   protowire.AppendFixed32(protowire.AppendTag(nil, 3, protowire.Fixed32Type), 1),
+	} {
+		if _, err := decodeProtobufUpload(body); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// Fields the schema doesn't know are skipped.
+	extra := protowire.AppendVarint(protowire.AppendTag(append([]byte{}, good...), 9, protowire.VarintType), 7)
+	if up, err := decodeProtobufUpload(extra); err != nil || len(up.Points) != 1 {
+		t.Errorf("unknown field: %v, %d points", err, len(up.Points))
+	}
+}
+
+func near(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
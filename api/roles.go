@@ -0,0 +1,77 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"log"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// permission is what a request needs its caller's role to grant.
+type permission int
+
+const (
+	// permRead reads the caller's own data
+	permRead permission = iota
+	// permWrite changes the caller's own data or settings
+	permWrite
+	// permUpload adds sensor readings
+	permUpload
+	// permAdmin manages other users
+	permAdmin
+	// permSelf manages the caller's own login
+	permSelf
+)
+
+func (p permission) String() string {
+	switch p {
+	case permRead:
+		return "read"
+	case permWrite:
+		return "write"
+	case permUpload:
+		return "upload"
+	case permAdmin:
+		return "admin"
+	case permSelf:
+		return "self"
+	}
+	return "unknown"
+}
+
+var rolePermissions = map[string][]permission{
+	kb.RoleAdmin:    {permRead, permWrite, permUpload, permAdmin, permSelf},
+	kb.RoleUser:     {permRead, permWrite, permUpload, permSelf},
+	kb.RoleReadOnly: {permRead, permSelf},
+	kb.RoleDevice:   {permUpload, permSelf},
+}
+
+// authorized reports whether user's account is enabled and its role grants
+// perm.
+func authorized(user string, perm permission, k kb.KB) bool {
+	account, ok := k.GetAccount(user)
+	if !ok || account.Disabled {
+		log.Printf("user '%s' missing or disabled\n", user)
+		return false
+	}
+	for _, p := range rolePermissions[account.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	log.Printf("user '%s' with role '%s' lacks %s permission\n", user, account.Role, perm)
+	return false
+}
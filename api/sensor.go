@@ -0,0 +1,210 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type sensorBody struct {
+	Token string `json:"token"`
+	kb.Sensor
+}
+
+type sensorResponse struct {
+	apiResponse
+	Sensors []kb.Sensor `json:"sensors"`
+}
+
+// SensorHandler registers sensors with their placement and hardware, lists
+// and changes them, and deletes their metadata.
+func SensorHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "POST" {
+		sensorPost(w, r, k)
+	} else if r.Method == "GET" {
+		sensorGet(w, r, k)
+	} else if r.Method == "PUT" {
+		sensorPut(w, r, k)
+	} else if r.Method == "DELETE" {
+		sensorDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+// sensorGet lists the caller's sensors and those shared with their
+// households, or only those named by sensor.
+func sensorGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	name := r.URL.Query().Get("sensor")
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	sr := new(sensorResponse)
+	sr.Token = newToken
+	sr.Success = true
+	sr.Sensors = make([]kb.Sensor, 0)
+	for _, sensor := range k.ListSensors(user) {
+		if name == "" || sensor.Name == name {
+			sr.Sensors = append(sr.Sensors, sensor)
+		}
+	}
+	payload, _ := json.Marshal(sr)
+	w.Write(payload)
+}
+
+func sensorPost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readSensorBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	rec.Owner = user
+	if !validSensor(w, &rec.Sensor, newToken, k) {
+		return
+	}
+	if !k.AddSensor(rec.Sensor) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not add sensor '%s' for user '%s'\n", rec.Name, user)
+		return
+	}
+
+	sr := new(sensorResponse)
+	sr.Token = newToken
+	sr.Success = true
+	sr.Sensors = []kb.Sensor{rec.Sensor}
+	payload, _ := json.Marshal(sr)
+	w.Write(payload)
+}
+
+// sensorPut replaces all of the metadata of one of the caller's sensors.
+func sensorPut(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	rec, ok := readSensorBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, newToken, ok := checkRequestToken(w, r, rec.Token, permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if _, ok = k.GetSensor(user, rec.Name); !ok {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("sensor '%s' not found for user '%s'\n", rec.Name, user)
+		return
+	}
+	rec.Owner = user
+	if !validSensor(w, &rec.Sensor, newToken, k) {
+		return
+	}
+	if !k.UpdateSensor(rec.Sensor) {
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not update sensor '%s' for user '%s'\n", rec.Name, user)
+		return
+	}
+
+	sr := new(sensorResponse)
+	sr.Token = newToken
+	sr.Success = true
+	sr.Sensors = []kb.Sensor{rec.Sensor}
+	payload, _ := json.Marshal(sr)
+	w.Write(payload)
+}
+
+func sensorDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	name := r.URL.Query().Get("sensor")
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	if !k.DeleteSensor(user, name) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("sensor '%s' not found for user '%s'\n", name, user)
+		return
+	}
+
+	success := apiResponse{true, newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+func readSensorBody(w http.ResponseWriter, r *http.Request) (*sensorBody, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		requestFailed(w, http.StatusNoContent)
+		log.Printf("empty or errant request body\n")
+		return nil, false
+	}
+
+	rec := new(sensorBody)
+	if json.Unmarshal(body, rec) != nil {
+		requestFailed(w, http.StatusInternalServerError)
+		log.Printf("request not in json format\n")
+		return nil, false
+	}
+	return rec, true
+}
+
+// validSensor checks the metadata of a sensor, answering the request itself
+// when it is unusable. The location must be one the owner can see.
+func validSensor(w http.ResponseWriter, sensor *kb.Sensor, newToken string, k kb.KB) bool {
+	if sensor.Name == "" || !kb.ValidPlacement(sensor.Surface, sensor.Side) ||
+		sensor.Removed != 0 && sensor.Removed < sensor.Installed {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad sensor '%s' on %s %s, installed %d removed %d\n",
+			sensor.Name, sensor.Side, sensor.Surface, sensor.Installed, sensor.Removed)
+		return false
+	}
+	if sensor.Location != 0 {
+		if _, ok := k.GetLocation(sensor.Owner, sensor.Location); !ok {
+			requestFailedToken(w, http.StatusNotFound, newToken)
+			log.Printf("location %d not found for user '%s'\n", sensor.Location, sensor.Owner)
+			return false
+		}
+	}
+	return true
+}
+
+// sensorMetadata maps the sensors the user can see, their own and those
+// shared with their households, to their metadata.
+func sensorMetadata(user string, k kb.KB) map[kb.SensorRef]kb.Sensor {
+	meta := make(map[kb.SensorRef]kb.Sensor)
+	for _, sensor := range k.ListSensors(user) {
+		meta[kb.SensorRef{Owner: sensor.Owner, Sensor: sensor.Name}] = sensor
+	}
+	return meta
+}
@@ -0,0 +1,145 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type sessionInfo struct {
+	kb.Session
+	Current bool `json:"current"`
+}
+
+type sessionResponse struct {
+	apiResponse
+	Sessions []sessionInfo `json:"sessions"`
+}
+
+// RevokeSession ends one of the user's sessions, or all of them when id is
+// empty. Signed tokens are never looked up in the tokens table, so their
+// sessions also go on the revocation list.
+func RevokeSession(k kb.KB, user, id string) bool {
+	ids := []string{id}
+	if id == "" {
+		ids = ids[:0]
+		for _, session := range k.ListSessions(user, time.Now().Unix()) {
+			ids = append(ids, session.ID)
+		}
+	}
+	if !k.RevokeSession(user, id) {
+		return false
+	}
+	if signedTokens() {
+		for _, id := range ids {
+			revokeSignedSession(id, k)
+		}
+	}
+	return true
+}
+
+// SessionHandler lists and revokes the caller's sessions, or with user=
+// another user's for admins.
+func SessionHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "GET" {
+		sessionGet(w, r, k)
+	} else if r.Method == "DELETE" {
+		sessionDelete(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func sessionGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	target := r.URL.Query().Get("user")
+	user, newToken, ok := checkRequestToken(w, r, "", sessionPermission(target), k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	if target == "" {
+		target = user
+	}
+
+	_, current, _ := tokenSession(newToken, k)
+	sr := new(sessionResponse)
+	sr.Token = newToken
+	sr.Success = true
+	sr.Sessions = make([]sessionInfo, 0)
+	for _, session := range k.ListSessions(target, time.Now().Unix()) {
+		sr.Sessions = append(sr.Sessions, sessionInfo{session, target == user && session.ID == current})
+	}
+	payload, _ := json.Marshal(sr)
+	w.Write(payload)
+}
+
+// sessionDelete revokes the session given by id, or every session with
+// all=true. When that includes the caller's own session no new token is
+// returned.
+func sessionDelete(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	target := params.Get("user")
+	user, newToken, ok := checkRequestToken(w, r, "", sessionPermission(target), k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	if target == "" {
+		target = user
+	}
+
+	id := params.Get("id")
+	if id == "" && params.Get("all") != "true" {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("missing session id\n")
+		return
+	}
+	_, current, _ := tokenSession(newToken, k)
+	if !RevokeSession(k, target, id) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("session '%s' not found for user '%s'\n", id, target)
+		return
+	}
+	if id == "" {
+		audit(r, k, user, kb.AuditSessionRevoked, target, "all sessions")
+	} else {
+		audit(r, k, user, kb.AuditSessionRevoked, target, "session "+id)
+	}
+
+	if target == user && (id == "" || id == current) {
+		w.Header().Del(tokenHeader)
+		newToken = ""
+	}
+	success := apiResponse{true, newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// sessionPermission is what it takes to manage the sessions of target,
+// which is the caller when empty.
+func sessionPermission(target string) permission {
+	if target == "" {
+		return permSelf
+	}
+	return permAdmin
+}
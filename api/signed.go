@@ -0,0 +1,199 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+// Signed tokens carry the user and expiration themselves and are checked
+// against an HMAC instead of the tokens table, so a client may keep using
+// one for its whole lifetime and from several connections at once. They are
+// enabled with tokenmode: signed and a tokensecret in the config.
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	s "strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+const signedPrefix = "v1."
+
+type signedClaims struct {
+	User    string `json:"u"`
+	Exp     int64  `json:"e"`
+	ID      string `json:"i"`
+	Session string `json:"s,omitempty"`
+}
+
+var (
+	secretOnce sync.Once
+	secret     []byte
+
+	revokedMu sync.RWMutex
+	revoked   = make(map[string]int64)
+)
+
+func signedTokens() bool {
+	return viper.GetString("tokenmode") == "signed"
+}
+
+func tokenSecret() []byte {
+	secretOnce.Do(func() {
+		secret = []byte(viper.GetString("tokensecret"))
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("no tokensecret configured, signed tokens will not survive a restart")
+		}
+	})
+	return secret
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, tokenSecret())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func generateSignedToken(user, session string) (string, error) {
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+	claims := signedClaims{
+		User:    user,
+		Exp:     time.Now().Unix() + viper.GetInt64("exptoken"),
+		ID:      fmt.Sprintf("%x", idBytes),
+		Session: session,
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := signedPrefix + base64.RawURLEncoding.EncodeToString(body)
+	return payload + "." + sign(payload), nil
+}
+
+// parseSignedToken verifies the signature and returns the claims without
+// checking expiration or revocation.
+func parseSignedToken(token string) (*signedClaims, bool) {
+	if !s.HasPrefix(token, signedPrefix) {
+		return nil, false
+	}
+	dot := s.LastIndex(token, ".")
+	if dot <= len(signedPrefix) {
+		return nil, false
+	}
+	payload, sig := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(sig), []byte(sign(payload))) {
+		return nil, false
+	}
+	body, err := base64.RawURLEncoding.DecodeString(payload[len(signedPrefix):])
+	if err != nil {
+		return nil, false
+	}
+	claims := new(signedClaims)
+	if json.Unmarshal(body, claims) != nil || claims.User == "" || claims.ID == "" {
+		return nil, false
+	}
+	return claims, true
+}
+
+// checkSignedToken accepts a valid, unexpired and unrevoked token. The same
+// token is handed back until it is past half its lifetime, when a fresh one
+// replaces it. Only then is the session's last use recorded.
+func checkSignedToken(token, clientIP string, k kb.KB) (user string, newToken string, ok bool) {
+	claims, ok := validSignedToken(token)
+	if !ok {
+		return "", "", false
+	}
+	now := time.Now().Unix()
+	if claims.Exp-now > viper.GetInt64("exptoken")/2 {
+		return claims.User, token, true
+	}
+	newToken, err := generateSignedToken(claims.User, claims.Session)
+	if err != nil {
+		log.Println(err)
+		return "", "", false
+	}
+	if claims.Session != "" {
+		k.TouchSession(claims.Session, now+viper.GetInt64("exptoken"), now, clientIP)
+	}
+	return claims.User, newToken, true
+}
+
+// validSignedToken returns the claims of a token that is currently usable.
+func validSignedToken(token string) (*signedClaims, bool) {
+	claims, ok := parseSignedToken(token)
+	if !ok || claims.Exp < time.Now().Unix() || isRevoked(claims.ID) || isRevoked(claims.Session) {
+		return nil, false
+	}
+	return claims, true
+}
+
+func isRevoked(id string) bool {
+	if id == "" {
+		return false
+	}
+	revokedMu.RLock()
+	defer revokedMu.RUnlock()
+	_, ok := revoked[id]
+	return ok
+}
+
+// revokeSignedToken puts a token on the revocation list until it would have
+// expired anyway.
+func revokeSignedToken(token string, k kb.KB) bool {
+	claims, ok := parseSignedToken(token)
+	if !ok {
+		return false
+	}
+	revokedMu.Lock()
+	revoked[claims.ID] = claims.Exp
+	revokedMu.Unlock()
+	return k.AddRevoked(claims.ID, claims.Exp)
+}
+
+// revokeSignedSession puts a whole session on the revocation list. None of
+// its tokens can outlive a token issued now.
+func revokeSignedSession(id string, k kb.KB) bool {
+	exp := time.Now().Unix() + viper.GetInt64("exptoken")
+	revokedMu.Lock()
+	revoked[id] = exp
+	revokedMu.Unlock()
+	return k.AddRevoked(id, exp)
+}
+
+// LoadRevoked refreshes the in-memory revocation list from the KB, which
+// also picks up tokens revoked by other servers sharing it.
+func LoadRevoked(k kb.KB) {
+	ids, ok := k.GetRevoked(time.Now().Unix())
+	if !ok {
+		return
+	}
+	revokedMu.Lock()
+	revoked = ids
+	revokedMu.Unlock()
+}
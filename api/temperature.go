@@ -16,30 +16,26 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
+	"mime"
 	"net/http"
 
 	"lachut.net/gogs/dslachut/go-irleak/kb"
 )
 
 type temperaturePostBody struct {
-	Token     string      `json:"token"`
-	Sensor    string      `json:"sensor"`
-	Value     float64     `json:"value"`
-	Timestamp float64     `json:"timestamp"`
-	Points    []tempPoint `json:"points"`
-}
-
-type tempPoint struct {
-	Value     float64 `json:"value"`
-	Timestamp float64 `json:"timestamp"`
+	Token     string            `json:"token"`
+	Sensor    string            `json:"sensor"`
+	Value     *float64          `json:"value"`
+	Timestamp *float64          `json:"timestamp"`
+	Points    []json.RawMessage `json:"points"`
 }
 
 func TemperatureHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
 	if r.Method == "POST" {
-		temperaturePost(w, r, k)
+		upload(w, r, k, func(w http.ResponseWriter) { temperaturePost(w, r, k) })
 	} else if r.Method == "GET" {
 		temperatureGet(w, r, k)
 	} else {
@@ -49,52 +45,90 @@ func TemperatureHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
 
 type tempResponse struct {
 	apiResponse
-	Sensors []temps `json:"sensors"`
+	Calibrated bool    `json:"calibrated"`
+	Sensors    []temps `json:"sensors"`
 }
 type temps struct {
-	Name   string              `json:"name"`
-	Values map[float64]float64 `json:"values"`
+	Owner    string              `json:"owner"`
+	Name     string              `json:"name"`
+	Values   map[float64]float64 `json:"values"`
+	Metadata *kb.Sensor          `json:"metadata,omitempty"`
 }
 
+// temperatureGet returns raw readings unless calibrated=true, in which case
+// the calibrations of each sensor's owner are applied to them.
 func temperatureGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
 	params := r.URL.Query()
-	reqToken, ok := params["token"]
+	user, newToken, allowed, ok := temperatureAuth(w, r, "", permRead, k)
 	if !ok {
 		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
 	}
-	user, newToken, ok := checkToken(reqToken, k)
+	start_ts, end_ts, ok := timeRange(params)
 	if !ok {
-		requestFailed(w, http.StatusForbidden)
-	}
-	start_ts, is_start := params["start"]
-	if !is_start {
-		start_ts = 0
-	}
-	end_ts, is_end := params["end"]
-	if !is_end {
-		end_ts = math.MaxFloat64
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad time range\n")
+		return
 	}
 
-	sens := make([]string, 0, 1)
-	sensor, is_sensor := params["sensor"]
-	if is_sensor {
-		sens = append(sens, sensor)
+	// Household members may have sensors of the same name; each one's
+	// readings are a series of their own.
+	refs := k.GetTemperatureRefs(user, start_ts, end_ts)
+	sens := make([]kb.SensorRef, 0, 1)
+	if sensor := params.Get("sensor"); sensor != "" {
+		if !sensorAllowed(allowed, sensor) {
+			requestFailedToken(w, http.StatusForbidden, newToken)
+			log.Printf("key not allowed sensor '%s'\n", sensor)
+			return
+		}
+		for _, ref := range refs {
+			if ref.Sensor == sensor {
+				sens = append(sens, ref)
+			}
+		}
+		if len(sens) == 0 {
+			sens = append(sens, kb.SensorRef{Owner: user, Sensor: sensor})
+		}
 	} else {
-		sens = k.GetTemperatureSensors(user, start_ts, end_ts)
+		for _, ref := range refs {
+			if sensorAllowed(allowed, ref.Sensor) {
+				sens = append(sens, ref)
+			}
+		}
 	}
 
 	tr := new(tempResponse)
 	tr.Token = newToken
 	tr.Success = true
+	tr.Calibrated = params.Get("calibrated") == "true"
 	tr.Sensors = make([]temps, 0, len(sens))
-	for _, sensor = range sens {
-		tr.Sensors = append(tr.Sensors, temps{sensor, k.GetTemperatures(user, sensor, start_ts, end_ts)})
+	meta := sensorMetadata(user, k)
+	for _, ref := range sens {
+		t := temps{Owner: ref.Owner, Name: ref.Sensor, Values: k.GetSensorTemperatures(user, ref, start_ts, end_ts)}
+		offset := 0.0
+		if m, ok := meta[ref]; ok {
+			t.Metadata = &m
+			offset = m.CalibrationOffset
+		}
+		if tr.Calibrated {
+			t.Values = calibrate(t.Values, k.ListCalibrations(ref.Owner, ref.Sensor), offset)
+		}
+		tr.Sensors = append(tr.Sensors, t)
 	}
 	payload, _ := json.Marshal(tr)
 	w.Write(payload)
 }
 
+// temperaturePost stores the points of one sensor, sent as JSON unless the
+// Content-Type names CBOR or protobuf, or the lines of a bulk upload.
 func temperaturePost(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if isBulkUpload(contentType) {
+		temperatureBulkPost(w, r, contentType, k)
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		requestFailed(w, http.StatusNoContent)
@@ -102,33 +136,58 @@ func temperaturePost(w http.ResponseWriter, r *http.Request, k kb.KB) {
 		return
 	}
 
-	rec := temperaturePostBody{}
-	if json.Unmarshal(body, &rec) != nil {
+	rec, err := decodeTemperatureUpload(contentType, body)
+	if err != nil {
 		requestFailed(w, http.StatusInternalServerError)
-		log.Printf("request not in json format\n")
+		log.Printf("request not in %s format\n", uploadFormat(contentType))
 		return
 	}
 
-	user, newToken, ok := checkToken(rec.Token, k)
+	user, newToken, allowed, ok := temperatureAuth(w, r, rec.Token, permUpload, k)
 	if !ok {
 		requestFailed(w, http.StatusForbidden)
 		log.Printf("invalid token")
 		return
 	}
+	if !sensorAllowed(allowed, rec.Sensor) {
+		requestFailedToken(w, http.StatusForbidden, newToken)
+		log.Printf("key not allowed sensor '%s'\n", rec.Sensor)
+		return
+	}
 
-	success := apiResponse{true, newToken}
-	if len(rec.Points) == 0 {
-		ok = k.AddTemperature(user, rec.Sensor, rec.Timestamp, rec.Value)
-		if !ok {
-			success = apiResponse{false, newToken}
-		}
-	} else {
-		for _, pt := range rec.Points {
-			ok = k.AddTemperature(user, rec.Sensor, pt.Timestamp, pt.Value)
-			success.Success = ok && success.Success
-		}
+	// The points that pass go in with one commit, and the response says
+	// why the others did not.
+	readings, rejected := checkReadings(user, rec.Sensor, rec.Points, k)
+	ok = true
+	var added int64
+	if len(readings) > 0 {
+		added, ok = k.AddTemperatures(user, rec.Sensor, readings)
+	}
+	ir := new(ingestResponse)
+	ir.Token = newToken
+	ir.Success = ok
+	ir.Rejected = rejected
+	if ok {
+		ir.stored(len(readings), added)
 	}
+	audit(r, k, user, kb.AuditUpload, user, fmt.Sprintf("%d points for sensor '%s', %d duplicate, %d rejected", ir.Accepted, rec.Sensor, ir.Duplicate, len(rejected)))
 
-	payload, _ := json.Marshal(success)
+	payload, _ := json.Marshal(ir)
 	w.Write(payload)
 }
+
+// temperatureAuth accepts either a session token or a device key, whose
+// owner's role must grant perm either way. Device keys are not rotated, so
+// newToken is empty for them, and allowed lists the sensors the key is
+// limited to.
+func temperatureAuth(w http.ResponseWriter, r *http.Request, bodyToken string, perm permission, k kb.KB) (user, newToken string, allowed []string, ok bool) {
+	if token := requestToken(r, bodyToken); isAPIKey(token) {
+		user, allowed, ok = checkAPIKey(token, k)
+		if !ok || !authorized(user, perm, k) {
+			return "", "", nil, false
+		}
+		return user, "", allowed, true
+	}
+	user, newToken, ok = checkRequestToken(w, r, bodyToken, perm, k)
+	return user, newToken, nil, ok
+}
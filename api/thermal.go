@@ -0,0 +1,320 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+
+	_ "golang.org/x/image/tiff"
+
+	"lachut.net/gogs/dslachut/go-irleak/ext"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+const (
+	maxFrameBytes  = 16 << 20
+	maxFramePixels = 1 << 20
+	// Radiometric images hold centikelvin unless scale and offset say
+	// otherwise.
+	defaultFrameScale  = 0.01
+	defaultFrameOffset = -273.15
+)
+
+type thermalPostBody struct {
+	Token     string    `json:"token"`
+	Sensor    string    `json:"sensor"`
+	Timestamp float64   `json:"timestamp"`
+	Width     int64     `json:"width"`
+	Height    int64     `json:"height"`
+	Pixels    []float64 `json:"pixels"`
+}
+
+type thermalResponse struct {
+	apiResponse
+	Frames []thermalFrame `json:"frames"`
+}
+
+// thermalFrame is a frame's statistics, and its pixels row by row when a
+// single frame is asked for.
+type thermalFrame struct {
+	kb.ThermalFrame
+	Pixels []float64 `json:"pixels,omitempty"`
+}
+
+// ThermalHandler uploads, returns and deletes infrared frames. Their
+// pixels are kept in blobs, the statistics in the KB.
+func ThermalHandler(w http.ResponseWriter, r *http.Request, k kb.KB, blobs ext.BlobStore) {
+	if r.Method == "POST" {
+		upload(w, r, k, func(w http.ResponseWriter) { thermalPost(w, r, k, blobs) })
+	} else if r.Method == "GET" {
+		thermalGet(w, r, k, blobs)
+	} else if r.Method == "DELETE" {
+		thermalDelete(w, r, k, blobs)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+// thermalGet returns the frame given by id with its pixels, or the
+// statistics of the frames taken between start and end, by sensor if given.
+func thermalGet(w http.ResponseWriter, r *http.Request, k kb.KB, blobs ext.BlobStore) {
+	params := r.URL.Query()
+	user, newToken, allowed, ok := temperatureAuth(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	tr := new(thermalResponse)
+	tr.Token = newToken
+	tr.Success = true
+	tr.Frames = make([]thermalFrame, 0)
+	if v := params.Get("id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		frame, found := k.GetThermalFrame(user, id)
+		if err != nil || !found || !sensorAllowed(allowed, frame.Sensor) {
+			requestFailedToken(w, http.StatusNotFound, newToken)
+			log.Printf("thermal frame '%s' not found for user '%s'\n", v, user)
+			return
+		}
+		data, err := blobs.Get(frame.Blob)
+		if err != nil {
+			requestFailedToken(w, http.StatusInternalServerError, newToken)
+			log.Printf("thermal frame %d: %v\n", frame.ID, err)
+			return
+		}
+		tr.Frames = append(tr.Frames, thermalFrame{frame, decodePixels(data)})
+	} else {
+		start_ts, end_ts, ok := timeRange(params)
+		if !ok {
+			requestFailedToken(w, http.StatusBadRequest, newToken)
+			log.Printf("bad time range\n")
+			return
+		}
+		for _, frame := range k.GetThermalFrames(user, params.Get("sensor"), start_ts, end_ts) {
+			if sensorAllowed(allowed, frame.Sensor) {
+				tr.Frames = append(tr.Frames, thermalFrame{frame, nil})
+			}
+		}
+	}
+	payload, _ := json.Marshal(tr)
+	w.Write(payload)
+}
+
+// thermalPost stores a frame sent either as JSON with its pixels in
+// degrees row by row, or as a 16 bit grayscale PNG or TIFF whose values are
+// turned into degrees with the scale and offset query parameters. For
+// images sensor and timestamp are query parameters too.
+func thermalPost(w http.ResponseWriter, r *http.Request, k kb.KB, blobs ext.BlobStore) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxFrameBytes))
+	if err != nil {
+		requestFailed(w, http.StatusRequestEntityTooLarge)
+		log.Printf("empty, errant or oversized request body\n")
+		return
+	}
+
+	rec := new(thermalPostBody)
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == "image/png" || contentType == "image/tiff" {
+		err = readRadiometric(rec, r, body)
+	} else {
+		err = json.Unmarshal(body, rec)
+	}
+	if err != nil {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad thermal frame: %v\n", err)
+		return
+	}
+
+	user, newToken, allowed, ok := temperatureAuth(w, r, rec.Token, permUpload, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	if !sensorAllowed(allowed, rec.Sensor) {
+		requestFailedToken(w, http.StatusForbidden, newToken)
+		log.Printf("key not allowed sensor '%s'\n", rec.Sensor)
+		return
+	}
+	if rec.Sensor == "" || !frameSizeOK(rec.Width, rec.Height) || int64(len(rec.Pixels)) != rec.Width*rec.Height {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad %dx%d thermal frame with %d pixels for sensor '%s'\n", rec.Width, rec.Height, len(rec.Pixels), rec.Sensor)
+		return
+	}
+
+	frame := kb.ThermalFrame{Owner: user, Sensor: rec.Sensor, Timestamp: rec.Timestamp, Width: rec.Width, Height: rec.Height}
+	frame.Min, frame.Max, frame.Mean = pixelStats(rec.Pixels)
+	if frame.Blob, err = newBlobKey("thermal"); err == nil {
+		err = blobs.Put(frame.Blob, encodePixels(rec.Pixels))
+	}
+	if err != nil {
+		requestFailedToken(w, http.StatusInternalServerError, newToken)
+		log.Printf("could not store thermal frame: %v\n", err)
+		return
+	}
+	if frame.ID, ok = k.AddThermalFrame(frame); !ok {
+		blobs.Delete(frame.Blob)
+		requestFailedToken(w, http.StatusConflict, newToken)
+		log.Printf("could not add thermal frame for sensor '%s' at %f\n", frame.Sensor, frame.Timestamp)
+		return
+	}
+	audit(r, k, user, kb.AuditUpload, user, fmt.Sprintf("%dx%d thermal frame for sensor '%s'", frame.Width, frame.Height, frame.Sensor))
+
+	tr := new(thermalResponse)
+	tr.Token = newToken
+	tr.Success = true
+	tr.Frames = []thermalFrame{{frame, nil}}
+	payload, _ := json.Marshal(tr)
+	w.Write(payload)
+}
+
+func thermalDelete(w http.ResponseWriter, r *http.Request, k kb.KB, blobs ext.BlobStore) {
+	user, newToken, ok := checkRequestToken(w, r, "", permWrite, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+
+	v := r.URL.Query().Get("id")
+	id, err := strconv.ParseInt(v, 10, 64)
+	frame, found := k.GetThermalFrame(user, id)
+	if err != nil || !found || frame.Owner != user || !k.DeleteThermalFrame(user, id) {
+		requestFailedToken(w, http.StatusNotFound, newToken)
+		log.Printf("thermal frame '%s' not found for user '%s'\n", v, user)
+		return
+	}
+	if err = blobs.Delete(frame.Blob); err != nil {
+		log.Printf("thermal frame %d: %v\n", frame.ID, err)
+	}
+	audit(r, k, user, kb.AuditDelete, user, fmt.Sprintf("thermal frame %d of sensor '%s'", frame.ID, frame.Sensor))
+
+	success := apiResponse{true, newToken}
+	payload, _ := json.Marshal(success)
+	w.Write(payload)
+}
+
+// readRadiometric decodes a radiometric image into rec, taking the other
+// fields from the query.
+func readRadiometric(rec *thermalPostBody, r *http.Request, body []byte) error {
+	params := r.URL.Query()
+	var err error
+	rec.Sensor = params.Get("sensor")
+	if rec.Timestamp, err = strconv.ParseFloat(params.Get("timestamp"), 64); err != nil {
+		return fmt.Errorf("bad timestamp %q", params.Get("timestamp"))
+	}
+	scale, offset := defaultFrameScale, defaultFrameOffset
+	if v := params.Get("scale"); v != "" {
+		if scale, err = strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("bad scale %q", v)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if offset, err = strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("bad offset %q", v)
+		}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if !frameSizeOK(int64(config.Width), int64(config.Height)) {
+		return fmt.Errorf("%dx%d image empty or too large", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	b := img.Bounds()
+	rec.Width, rec.Height = int64(b.Dx()), int64(b.Dy())
+	rec.Pixels = make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var raw float64
+			switch gray := img.(type) {
+			case *image.Gray16:
+				raw = float64(gray.Gray16At(x, y).Y)
+			case *image.Gray:
+				raw = float64(gray.GrayAt(x, y).Y)
+			default:
+				return fmt.Errorf("%T is not a grayscale image", img)
+			}
+			rec.Pixels = append(rec.Pixels, raw*scale+offset)
+		}
+	}
+	return nil
+}
+
+// frameSizeOK tells whether a width x height frame has pixels and no more
+// than maxFramePixels of them. The bounds are checked before multiplying, so
+// huge dimensions can't overflow into a small product.
+func frameSizeOK(width, height int64) bool {
+	return width > 0 && height > 0 && width <= maxFramePixels && height <= maxFramePixels/width
+}
+
+func pixelStats(pixels []float64) (min, max, mean float64) {
+	min, max = math.Inf(1), math.Inf(-1)
+	sum := 0.0
+	for _, p := range pixels {
+		min = math.Min(min, p)
+		max = math.Max(max, p)
+		sum += p
+	}
+	return min, max, sum / float64(len(pixels))
+}
+
+// Pixels are stored as little-endian float32, which is more precision than
+// any thermal camera has.
+
+func encodePixels(pixels []float64) []byte {
+	data := make([]byte, 4*len(pixels))
+	for i, p := range pixels {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(float32(p)))
+	}
+	return data
+}
+
+func decodePixels(data []byte) []float64 {
+	pixels := make([]float64, len(data)/4)
+	for i := range pixels {
+		pixels[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+	}
+	return pixels
+}
+
+// newBlobKey returns a random key below prefix.
+func newBlobKey(prefix string) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%x", prefix, idBytes), nil
+}
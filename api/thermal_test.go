@@ -0,0 +1,45 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFrameSizeOK(t *testing.T) {
+	tests := []struct {
+		width, height int64
+		want          bool
+	}{
+		{32, 24, true},
+		{1, maxFramePixels, true},
+		{maxFramePixels, 1, true},
+		{1024, 1024, true},
+		{1024, 1025, false},
+		{0, 24, false},
+		{32, 0, false},
+		{-32, -24, false},
+		{maxFramePixels + 1, 1, false},
+		{1 << 32, 1 << 32, false},
+		{math.MaxInt64, 2, false},
+		{3, math.MaxInt64/3 + 1, false},
+	}
+	for _, tt := range tests {
+		if got := frameSizeOK(tt.width, tt.height); got != tt.want {
+			t.Errorf("frameSizeOK(%d, %d) = %v, want %v", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"lachut.net/gogs/dslachut/go-irleak/ext"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// ErrLastHouseholdAdmin is returned for a user who may not be deleted
+// because other members of one of their households would be left without
+// an admin.
+var ErrLastHouseholdAdmin = errors.New("last admin of a household with other members")
+
+// CheckDeleteUser reports why the user may not be deleted, if they may
+// not. Like a demotion, a deletion may not leave a household with members
+// but no admin; someone else must be made admin first.
+func CheckDeleteUser(k kb.KB, user string) error {
+	for _, h := range k.ListHouseholds(user) {
+		if lastHouseholdAdmin(h.ID, user, k) && len(k.ListMembers(h.ID)) > 1 {
+			return fmt.Errorf("user '%s': %w, household %d", user, ErrLastHouseholdAdmin, h.ID)
+		}
+	}
+	return nil
+}
+
+// DeleteUser deletes the user, and the households no one else was in, with
+// kb.KB.DeleteUser, then the pixels of the user's thermal frames, which the
+// KB does not hold. blobs may be nil when thermal frames are turned
+// off. A user CheckDeleteUser refuses is not deleted.
+func DeleteUser(k kb.KB, blobs ext.BlobStore, user string) error {
+	if err := CheckDeleteUser(k, user); err != nil {
+		return err
+	}
+	var frames []kb.ThermalFrame
+	if blobs != nil {
+		frames = k.GetThermalFrames(user, "", -math.MaxFloat64, math.MaxFloat64)
+	}
+	if !k.DeleteUser(user) {
+		return fmt.Errorf("could not delete user '%s'", user)
+	}
+
+	for _, frame := range frames {
+		if frame.Owner != user {
+			continue
+		}
+		if err := blobs.Delete(frame.Blob); err != nil {
+			log.Printf("thermal frame %d: %v\n", frame.ID, err)
+		}
+	}
+	return nil
+}
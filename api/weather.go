@@ -0,0 +1,70 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+type weatherResponse struct {
+	apiResponse
+	Location kb.Location  `json:"location"`
+	Weather  []kb.Weather `json:"weather"`
+}
+
+func WeatherHandler(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	if r.Method == "GET" {
+		weatherGet(w, r, k)
+	} else {
+		requestFailed(w, http.StatusBadRequest)
+		log.Printf("bad request method %s\n", r.Method)
+	}
+}
+
+func weatherGet(w http.ResponseWriter, r *http.Request, k kb.KB) {
+	params := r.URL.Query()
+	user, newToken, ok := checkRequestToken(w, r, "", permRead, k)
+	if !ok {
+		requestFailed(w, http.StatusForbidden)
+		log.Printf("invalid token\n")
+		return
+	}
+	start_ts, end_ts, ok := timeRange(params)
+	if !ok {
+		requestFailedToken(w, http.StatusBadRequest, newToken)
+		log.Printf("bad time range\n")
+		return
+	}
+
+	loc, ok := userLocation(w, params, user, newToken, k)
+	if !ok {
+		return
+	}
+
+	wr := new(weatherResponse)
+	wr.Token = newToken
+	wr.Success = true
+	wr.Location = loc
+	wr.Weather = k.GetWeather(loc.ID, start_ts, end_ts)
+	if wr.Weather == nil {
+		wr.Weather = []kb.Weather{}
+	}
+	payload, _ := json.Marshal(wr)
+	w.Write(payload)
+}
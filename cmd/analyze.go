@@ -0,0 +1,145 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"lachut.net/gogs/dslachut/go-irleak/analysis"
+)
+
+var (
+	analyzeLocation int64
+	analyzeSensors  []string
+	analyzeStart    float64
+	analyzeEnd      float64
+	analyzeInterval float64
+	analyzeCap      float64
+	analyzeBase     float64
+	analyzeTZ       string
+	analyzeMonthly  bool
+)
+
+// analyzeCmd groups the energy analyses
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Run energy analyses on stored data",
+	Long: `Run energy analyses on the data stored for a user's location.
+
+Usage: irleak analyze heatloss username --location 1`,
+}
+
+// heatlossCmd represents the analyze heatloss command
+var heatlossCmd = &cobra.Command{
+	Use:   "heatloss username",
+	Short: "Estimate a building's heat loss coefficient",
+	Long: `Fit a lumped RC thermal model to the nighttime cooldown of a user's
+indoor sensors against the outdoor temperature of one of their locations.
+
+Usage: irleak analyze heatloss username --location 1 [--sensor s1 --sensor s2]`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		if _, ok := k.GetLocation(args[0], analyzeLocation); !ok {
+			log.Fatalf("location %d not found for user '%s'", analyzeLocation, args[0])
+		}
+
+		capacitance := analyzeCap
+		if !cmd.Flags().Changed("capacitance") {
+			capacitance = viper.GetFloat64("capacitance")
+		}
+		p := analysis.HeatLossParams{Interval: analyzeInterval, Capacitance: capacitance}
+		hl, err := analysis.EstimateHeatLoss(k, args[0], analyzeLocation, analyzeSensors, analyzeStart, analyzeEnd, p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("heat loss for %s at location %d from %d cooldown steps:\n", args[0], analyzeLocation, hl.Points)
+		fmt.Printf("\ttime constant: %.2f h (95%% CI %.2f - %.2f)\n", hl.TimeConstant, hl.TimeConstantLow, hl.TimeConstantHigh)
+		if capacitance > 0 {
+			fmt.Printf("\tUA: %.2f W/K (95%% CI %.2f - %.2f)\n", hl.UA, hl.UALow, hl.UAHigh)
+		} else {
+			fmt.Printf("\tUA: unknown, set --capacitance to estimate it\n")
+		}
+	},
+}
+
+// degreedaysCmd represents the analyze degreedays command
+var degreedaysCmd = &cobra.Command{
+	Use:   "degreedays username",
+	Short: "Total heating and cooling degree days for a location",
+	Long: `Compute daily and monthly heating and cooling degree days from the
+weather collected for one of a user's locations.
+
+Usage: irleak analyze degreedays username --location 1 [--base 18 --tz America/New_York]`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		if _, ok := k.GetLocation(args[0], analyzeLocation); !ok {
+			log.Fatalf("location %d not found for user '%s'", analyzeLocation, args[0])
+		}
+
+		base := analyzeBase
+		if !cmd.Flags().Changed("base") {
+			base = viper.GetFloat64("degreedaybase")
+		}
+		tz, err := time.LoadLocation(analyzeTZ)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dd := analysis.EstimateDegreeDays(k, analyzeLocation, analyzeStart, analyzeEnd, base, tz)
+		rows := dd.Daily
+		if analyzeMonthly {
+			rows = dd.Monthly
+		}
+		fmt.Printf("degree days for %s at location %d, base %.1f:\n", args[0], analyzeLocation, base)
+		fmt.Printf("%-10s\t%8s\t%8s\t%8s\n", "period", "HDD", "CDD", "days")
+		for _, row := range rows {
+			fmt.Printf("%-10s\t%8.2f\t%8.2f\t%8.2f\n", row.Period, row.HDD, row.CDD, row.Coverage)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(analyzeCmd)
+	analyzeCmd.AddCommand(heatlossCmd)
+	analyzeCmd.AddCommand(degreedaysCmd)
+
+	analyzeCmd.PersistentFlags().Int64Var(&analyzeLocation, "location", 0, "location id")
+	analyzeCmd.PersistentFlags().Float64Var(&analyzeStart, "start", 0, "start of the range as a unix timestamp")
+	analyzeCmd.PersistentFlags().Float64Var(&analyzeEnd, "end", math.MaxFloat64, "end of the range as a unix timestamp")
+
+	heatlossCmd.Flags().StringSliceVar(&analyzeSensors, "sensor", nil, "indoor sensor, may be repeated (default all)")
+	heatlossCmd.Flags().Float64Var(&analyzeInterval, "interval", 900, "resampling interval in seconds")
+	heatlossCmd.Flags().Float64Var(&analyzeCap, "capacitance", 0, "thermal capacitance of the building in J/K")
+
+	degreedaysCmd.Flags().Float64Var(&analyzeBase, "base", analysis.DefaultDegreeDayBase, "base temperature")
+	degreedaysCmd.Flags().StringVar(&analyzeTZ, "tz", "UTC", "time zone that days are counted in")
+	degreedaysCmd.Flags().BoolVar(&analyzeMonthly, "monthly", false, "print monthly instead of daily totals")
+}
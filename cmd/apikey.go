@@ -0,0 +1,119 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	s "strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/api"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+var apikeySensors []string
+
+// apikeyCmd groups the device key commands
+var apikeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Manage device API keys",
+	Long: `Manage the long-lived API keys that unattended IRLeak devices use to
+upload temperatures in place of a session token.`,
+}
+
+// apikeyAddCmd represents the apikey add command
+var apikeyAddCmd = &cobra.Command{
+	Use:   "add username keyname",
+	Short: "Mint a device key for a user",
+	Long: `Mint a device key for a user, optionally limited to some sensors.
+The key is printed once and cannot be recovered.
+
+Usage: irleak apikey add username keyname [--sensor s1 --sensor s2]`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		if _, ok := k.GetHash(args[0]); !ok {
+			log.Fatalf("user '%s' not found", args[0])
+		}
+		full, key, err := api.NewAPIKey(k, args[0], args[1], apikeySensors)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cliAudit(k, kb.AuditKeyCreated, args[0], "key "+key.ID)
+		fmt.Printf("apikey add called:\n\tuser: %s\n\tname: %s\n\tid: %s\n\tkey: %s\n", args[0], key.Name, key.ID, full)
+	},
+}
+
+// apikeyListCmd represents the apikey list command
+var apikeyListCmd = &cobra.Command{
+	Use:   "list username",
+	Short: "List a user's device keys",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		for _, key := range k.ListAPIKeys(args[0]) {
+			status := "active"
+			if key.Revoked != 0 {
+				status = "revoked " + time.Unix(key.Revoked, 0).Format(time.RFC3339)
+			}
+			sensors := "all sensors"
+			if len(key.Sensors) > 0 {
+				sensors = s.Join(key.Sensors, ",")
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, time.Unix(key.Created, 0).Format(time.RFC3339), sensors, status)
+		}
+	},
+}
+
+// apikeyRevokeCmd represents the apikey revoke command
+var apikeyRevokeCmd = &cobra.Command{
+	Use:   "revoke username keyid",
+	Short: "Revoke a device key",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		ok := k.RevokeAPIKey(args[0], args[1], time.Now().Unix())
+		if ok {
+			cliAudit(k, kb.AuditKeyRevoked, args[0], "key "+args[1])
+		}
+		fmt.Printf("apikey revoke called:\n\tuser: %s\n\tid: %s\n\tsuccess: %v\n", args[0], args[1], ok)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(apikeyCmd)
+	apikeyCmd.AddCommand(apikeyAddCmd)
+	apikeyCmd.AddCommand(apikeyListCmd)
+	apikeyCmd.AddCommand(apikeyRevokeCmd)
+
+	apikeyAddCmd.Flags().StringSliceVar(&apikeySensors, "sensor", nil, "sensor the key may upload for, may be repeated (default all)")
+}
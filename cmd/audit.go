@@ -0,0 +1,72 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os/user"
+	"time"
+
+	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/api"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+var auditUser string
+var auditStart int64
+var auditEnd int64
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Query the audit log",
+	Long: `Print the audit log of logins, failed logins, revoked sessions and keys,
+user changes, uploads and deletions, oldest first. With --user, only the
+events caused by or done to that user.
+
+Usage: irleak audit [--user username] [--start ts] [--end ts]`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		for _, event := range k.GetAudit(auditUser, auditStart, auditEnd) {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", time.Unix(event.Timestamp, 0).Format(time.RFC3339),
+				event.User, event.Action, event.Subject, event.ClientIP, event.Detail)
+		}
+	},
+}
+
+// cliAudit records an event caused by a command run on the server host. The
+// operating system user stands in for the IRLeak user.
+func cliAudit(k kb.KB, action, subject, detail string) {
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor = "cli:" + u.Username
+	}
+	api.Audit(k, actor, action, subject, "local", detail)
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().StringVar(&auditUser, "user", "", "only events caused by or done to this user")
+	auditCmd.Flags().Int64Var(&auditStart, "start", 0, "start of the range as a unix timestamp")
+	auditCmd.Flags().Int64Var(&auditEnd, "end", math.MaxInt64, "end of the range as a unix timestamp")
+}
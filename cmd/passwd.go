@@ -0,0 +1,76 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bgentry/speakeasy"
+	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/api"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// passwdCmd represents the passwd command
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Reset a user's password",
+	Long: `Reset the password of a user in the database of the IRLeak Server
+
+Usage: irleak passwd username [password]`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		var password string
+		var err error
+		if len(args) == 2 {
+			password = args[1]
+		} else {
+			password, err = speakeasy.Ask("New password for IRLeak user: ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			pass2, err := speakeasy.Ask("Please re-enter the password:")
+			if err != nil {
+				log.Fatal(err)
+			}
+			if password != pass2 {
+				log.Fatal("New password does not match")
+			}
+		}
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		if _, ok := k.GetHash(args[0]); !ok {
+			log.Fatalf("user '%s' not found", args[0])
+		}
+		hash, err := api.HashPassword(password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ok := k.SetHash(args[0], hash)
+		if ok {
+			cliAudit(k, kb.AuditPassword, args[0], "reset")
+		}
+		fmt.Printf("passwd called:\n\tuser: %s\n\tsuccess: %v\n", args[0], ok)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(passwdCmd)
+}
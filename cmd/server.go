@@ -48,6 +48,7 @@ to quickly create a Cobra application.`,
 			log.Fatal("No knowledge base configured.")
 		}
 		w := getWeather()
+		blobs := getBlobStore()
 		// Kick-off background tasks
 		done := make([]chan bool, 0, 2)
 		// Bg task 1: clean up tokens from KB
@@ -55,11 +56,13 @@ to quickly create a Cobra application.`,
 		go api.PurgeTokens(activeKB, purgeDone)
 		done = append(done, purgeDone)
 		// Bg task 2: fetch weather data
-		weatherDone := make(chan bool)
-		go w.DoFetch(activeKB, weatherDone)
-		done = append(done, weatherDone)
+		if w != nil {
+			weatherDone := make(chan bool)
+			go w.DoFetch(activeKB, weatherDone)
+			done = append(done, weatherDone)
+		}
 		// Catch signals to shutdown system
-		sigs := make(chan os.Signal)
+		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 		go func() {
 			sig := <-sigs
@@ -74,10 +77,73 @@ to quickly create a Cobra application.`,
 		http.HandleFunc("/api/temp", func(w http.ResponseWriter, r *http.Request) {
 			api.TemperatureHandler(w, r, activeKB)
 		})
+		// Register measurement API
+		http.HandleFunc("/api/measurements", func(w http.ResponseWriter, r *http.Request) {
+			api.MeasurementHandler(w, r, activeKB)
+		})
+		// Register thermal frame API
+		if blobs != nil {
+			http.HandleFunc("/api/thermal", func(w http.ResponseWriter, r *http.Request) {
+				api.ThermalHandler(w, r, activeKB, blobs)
+			})
+		}
+		// Register sensor metadata API
+		http.HandleFunc("/api/sensors", func(w http.ResponseWriter, r *http.Request) {
+			api.SensorHandler(w, r, activeKB)
+		})
+		http.HandleFunc("/api/calibrations", func(w http.ResponseWriter, r *http.Request) {
+			api.CalibrationHandler(w, r, activeKB)
+		})
+		// Register location API
+		http.HandleFunc("/api/location", func(w http.ResponseWriter, r *http.Request) {
+			api.LocationHandler(w, r, activeKB)
+		})
+		// Register weather API
+		http.HandleFunc("/api/weather", func(w http.ResponseWriter, r *http.Request) {
+			api.WeatherHandler(w, r, activeKB)
+		})
+		// Register aligned indoor/outdoor series API
+		http.HandleFunc("/api/aligned", func(w http.ResponseWriter, r *http.Request) {
+			api.AlignedHandler(w, r, activeKB)
+		})
+		// Register analysis API
+		http.HandleFunc("/api/analysis/heatloss", func(w http.ResponseWriter, r *http.Request) {
+			api.HeatLossHandler(w, r, activeKB)
+		})
+		http.HandleFunc("/api/analysis/degreedays", func(w http.ResponseWriter, r *http.Request) {
+			api.DegreeDayHandler(w, r, activeKB)
+		})
+		// Register device key API
+		http.HandleFunc("/api/keys", func(w http.ResponseWriter, r *http.Request) {
+			api.APIKeyHandler(w, r, activeKB)
+		})
+		// Register household API
+		http.HandleFunc("/api/households", func(w http.ResponseWriter, r *http.Request) {
+			api.HouseholdHandler(w, r, activeKB)
+		})
+		http.HandleFunc("/api/households/members", func(w http.ResponseWriter, r *http.Request) {
+			api.HouseholdMemberHandler(w, r, activeKB)
+		})
+		http.HandleFunc("/api/households/sensors", func(w http.ResponseWriter, r *http.Request) {
+			api.HouseholdSensorHandler(w, r, activeKB)
+		})
+		http.HandleFunc("/api/households/locations", func(w http.ResponseWriter, r *http.Request) {
+			api.HouseholdLocationHandler(w, r, activeKB)
+		})
+		// Register admin API
+		http.HandleFunc("/api/admin/users", func(w http.ResponseWriter, r *http.Request) {
+			api.AdminUserHandler(w, r, activeKB, blobs)
+		})
 		// Register auth API
 		http.HandleFunc("/api/auth", func(w http.ResponseWriter, r *http.Request) {
 			api.AuthHandler(w, r, activeKB)
 		})
+		http.HandleFunc("/api/auth/password", func(w http.ResponseWriter, r *http.Request) {
+			api.PasswordHandler(w, r, activeKB)
+		})
+		http.HandleFunc("/api/sessions", func(w http.ResponseWriter, r *http.Request) {
+			api.SessionHandler(w, r, activeKB)
+		})
 		// Start the web front
 		port := viper.GetString("port")
 		log.Printf("serving IRLeak API on port %s\n", port)
@@ -90,8 +156,27 @@ func conf() {
 	viper.SetDefault("dbtype", "sqlite")
 	viper.SetDefault("dbparams", map[string]string{"file": "tmp.db"})
 	viper.SetDefault("exptoken", 3600)
+	viper.SetDefault("tokenmode", "rotating")
+	viper.SetDefault("revokedrefresh", 60)
+	viper.SetDefault("querytokens", true)
+	viper.SetDefault("trustproxy", false)
+	viper.SetDefault("lockoutthreshold", 5)
+	viper.SetDefault("lockoutipthreshold", 20)
+	viper.SetDefault("lockoutdelay", 1)
+	viper.SetDefault("lockoutmaxdelay", 3600)
+	viper.SetDefault("lockoutwindow", 3600)
+	viper.SetDefault("idempotencywindow", 86400)
+	viper.SetDefault("idempotencylease", 60)
+	viper.SetDefault("mintemperature", -60.0)
+	viper.SetDefault("maxtemperature", 100.0)
+	viper.SetDefault("maxfuture", 300)
+	viper.SetDefault("maxage", 0)
+	viper.SetDefault("blobtype", "file")
+	viper.SetDefault("blobparams", map[string]string{"dir": "blobs"})
 	viper.SetDefault("weathertype", "darksky")
 	viper.SetDefault("weatherparams", map[string]string{"key": ""})
+	viper.SetDefault("capacitance", 0)
+	viper.SetDefault("degreedaybase", 18.0)
 	viper.SetConfigName("config")
 	viper.AddConfigPath("$HOME/.irleak")
 	viper.AddConfigPath("$HOME/.config/irleak/")
@@ -101,19 +186,63 @@ func conf() {
 		log.Println(err)
 		log.Println("config file not found, using defaults")
 	}
-	log.Printf("%v\n", viper.AllSettings())
+	log.Printf("%v\n", redacted(viper.AllSettings()))
 }
 
-func getWeather() ext.Weather {
-	switch {
-	case viper.GetString("weathertype") == "darksky":
-		key := viper.GetStringMapString("weatherparams")["key"]
-		if key == "" {
-			return nil
+// secretSettings are the config keys, at any depth, whose values are kept
+// out of the log.
+var secretSettings = map[string]bool{"tokensecret": true, "password": true, "key": true}
+
+// redacted returns a copy of settings with the values of secret keys
+// masked.
+func redacted(settings map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(settings))
+	for name, value := range settings {
+		switch nested := value.(type) {
+		case map[string]interface{}:
+			out[name] = redacted(nested)
+		case map[string]string:
+			plain := make(map[string]interface{}, len(nested))
+			for k, v := range nested {
+				plain[k] = v
+			}
+			out[name] = redacted(plain)
+		default:
+			out[name] = value
+		}
+		if secretSettings[name] {
+			out[name] = "<redacted>"
 		}
-		return ext.NewDarkSky(key)
 	}
-	return nil
+	return out
+}
+
+func getWeather() ext.Weather {
+	wtype := viper.GetString("weathertype")
+	if wtype == "" || wtype == "none" {
+		return nil
+	}
+	w, err := ext.NewWeather(wtype, viper.GetStringMapString("weatherparams"))
+	if err != nil {
+		log.Println(err)
+		log.Println("weather fetching disabled")
+		return nil
+	}
+	return w
+}
+
+func getBlobStore() ext.BlobStore {
+	btype := viper.GetString("blobtype")
+	if btype == "" || btype == "none" {
+		return nil
+	}
+	b, err := ext.NewBlobStore(btype, viper.GetStringMapString("blobparams"))
+	if err != nil {
+		log.Println(err)
+		log.Println("thermal frames disabled")
+		return nil
+	}
+	return b
 }
 
 func getKB() kb.KB {
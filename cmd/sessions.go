@@ -0,0 +1,101 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/api"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+var sessionsAll bool
+
+// sessionsCmd groups the session commands
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage users' login sessions",
+	Long: `List and revoke the sessions users have started by logging in. Revoking
+a session invalidates its tokens right away; servers using signed tokens
+notice within revokedrefresh seconds.`,
+}
+
+// sessionsListCmd represents the sessions list command
+var sessionsListCmd = &cobra.Command{
+	Use:   "list username",
+	Short: "List a user's active sessions",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		for _, session := range k.ListSessions(args[0], time.Now().Unix()) {
+			fmt.Printf("%s\tcreated: %s\texpires: %s\tlast used: %s\tfrom: %s\n", session.ID,
+				time.Unix(session.Created, 0).Format(time.RFC3339),
+				time.Unix(session.Expires, 0).Format(time.RFC3339),
+				time.Unix(session.LastUsed, 0).Format(time.RFC3339),
+				session.ClientIP)
+		}
+	},
+}
+
+// sessionsRevokeCmd represents the sessions revoke command
+var sessionsRevokeCmd = &cobra.Command{
+	Use:   "revoke username [sessionid]",
+	Short: "Revoke one or all of a user's sessions",
+	Long: `Revoke one of a user's sessions, or all of them with --all.
+
+Usage: irleak sessions revoke username sessionid
+       irleak sessions revoke username --all`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if sessionsAll {
+			return cobra.ExactArgs(1)(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		id := ""
+		if !sessionsAll {
+			id = args[1]
+		}
+		ok := api.RevokeSession(k, args[0], id)
+		if ok && id == "" {
+			cliAudit(k, kb.AuditSessionRevoked, args[0], "all sessions")
+		} else if ok {
+			cliAudit(k, kb.AuditSessionRevoked, args[0], "session "+id)
+		}
+		fmt.Printf("sessions revoke called:\n\tuser: %s\n\tid: %s\n\tsuccess: %v\n", args[0], id, ok)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsRevokeCmd)
+
+	sessionsRevokeCmd.Flags().BoolVar(&sessionsAll, "all", false, "revoke every session of the user")
+}
@@ -0,0 +1,80 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+var unlockIP bool
+var unlockList bool
+
+// unlockCmd represents the unlock command
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Clear failed logins of a user or client address",
+	Long: `Clear the failed login count and lockout of a user, or of a client
+address with --ip. With --list, show every user and address with failed logins.
+
+Usage: irleak unlock username
+       irleak unlock --ip address
+       irleak unlock --list`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if unlockList {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+
+		if unlockList {
+			now := time.Now().Unix()
+			for _, f := range k.ListLoginFailures() {
+				locked := "no"
+				if f.LockedUntil > now {
+					locked = time.Unix(f.LockedUntil, 0).Format(time.RFC3339)
+				}
+				fmt.Printf("%s\t%s\tfailures: %d\tlast: %s\tlocked until: %s\n",
+					f.Kind, f.Subject, f.Failures,
+					time.Unix(f.LastFailure, 0).Format(time.RFC3339), locked)
+			}
+			return
+		}
+
+		kind := kb.FailureUser
+		if unlockIP {
+			kind = kb.FailureIP
+		}
+		ok := k.ClearLoginFailure(kind, args[0])
+		fmt.Printf("unlock called:\n\t%s: %s\n\tsuccess: %v\n", kind, args[0], ok)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(unlockCmd)
+	unlockCmd.Flags().BoolVar(&unlockIP, "ip", false, "unlock a client address instead of a user")
+	unlockCmd.Flags().BoolVarP(&unlockList, "list", "l", false, "list users and addresses with failed logins")
+}
@@ -19,19 +19,25 @@ import (
 	"log"
 
 	"github.com/bgentry/speakeasy"
-	"github.com/elithrar/simple-scrypt"
 	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/api"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
 )
 
+var useraddRole string
+
 // useraddCmd represents the useradd command
 var useraddCmd = &cobra.Command{
 	Use:   "useradd",
 	Short: "Add a user to the database",
 	Long: `Add a user to the database of the IRLeak Server
 
-Usage: irleak useradd username password`,
+Usage: irleak useradd username password [--role admin|user|read-only|device]`,
 	Run: func(cmd *cobra.Command, args []string) {
 		conf()
+		if !kb.ValidRole(useraddRole) {
+			log.Fatalf("unknown role '%s'", useraddRole)
+		}
 		var password string
 		var err error
 		if len(args) == 2 {
@@ -49,14 +55,20 @@ Usage: irleak useradd username password`,
 				log.Fatal("New password does not match")
 			}
 		}
-		hashBytes, err := scrypt.GenerateFromPassword([]byte(password), scrypt.DefaultParams)
+		hash, err := api.HashPassword(password)
 		if err != nil {
 			log.Fatal(err)
 		}
-		hash := string(hashBytes)
 		k := getKB()
-		ok := k.AddUser(args[0], hash)
-		fmt.Printf("useradd called:\n\tuser: %s\n\tsuccess: %v\n", args[0], ok)
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+		ok := k.AddUser(args[0], hash) && k.SetRole(args[0], useraddRole)
+		if ok {
+			cliAudit(k, kb.AuditUserCreated, args[0], "role "+useraddRole)
+		}
+		fmt.Printf("useradd called:\n\tuser: %s\n\trole: %s\n\tsuccess: %v\n", args[0], useraddRole, ok)
 	},
 }
 
@@ -72,5 +84,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// useraddCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-
+	useraddCmd.Flags().StringVar(&useraddRole, "role", kb.RoleUser, "role of the new user: admin, user, read-only or device")
 }
@@ -0,0 +1,91 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"lachut.net/gogs/dslachut/go-irleak/api"
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+var userdelArchive string
+
+// userdelCmd represents the userdel command
+var userdelCmd = &cobra.Command{
+	Use:   "userdel",
+	Short: "Delete a user and all of their data",
+	Long: `Delete a user from the database of the IRLeak Server together with
+their tokens, device keys, measurements, thermal frames, locations and the
+weather recorded for those locations. With --archive the data is first written to a new JSON
+file, and nothing is deleted if that fails.
+
+Usage: irleak userdel username [--archive file]`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf()
+		k := getKB()
+		if k == nil {
+			log.Fatal("No knowledge base configured.")
+		}
+		defer k.Stop()
+
+		if err := api.CheckDeleteUser(k, args[0]); err != nil {
+			log.Fatal(err)
+		}
+		if userdelArchive != "" {
+			archive, ok := kb.ExportUser(k, args[0])
+			if !ok {
+				log.Fatalf("user '%s' not found", args[0])
+			}
+			payload, err := json.MarshalIndent(archive, "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			f, err := os.OpenFile(userdelArchive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, err = f.Write(payload); err != nil {
+				f.Close()
+				log.Fatal(err)
+			}
+			if err = f.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		err := api.DeleteUser(k, getBlobStore(), args[0])
+		if err != nil {
+			log.Println(err)
+		}
+		ok := err == nil
+		if ok && userdelArchive != "" {
+			cliAudit(k, kb.AuditUserDeleted, args[0], "with all data, archived to "+userdelArchive)
+		} else if ok {
+			cliAudit(k, kb.AuditUserDeleted, args[0], "with all data")
+		}
+		fmt.Printf("userdel called:\n\tuser: %s\n\tsuccess: %v\n", args[0], ok)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(userdelCmd)
+	userdelCmd.Flags().StringVar(&userdelArchive, "archive", "", "write the user's data to this file before deleting")
+}
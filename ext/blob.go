@@ -0,0 +1,81 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get for keys that were never
+// stored or have been deleted.
+var ErrBlobNotFound = errors.New("ext: blob not found")
+
+// BlobStore keeps binary data too large for the KB, such as the pixels of
+// thermal frames, under keys the KB refers to. Keys are slash separated
+// paths like thermal/2a9f.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	// Delete removes the blob, succeeding if it is already gone.
+	Delete(key string) error
+}
+
+// BlobFactory builds a BlobStore from the blobparams config map.
+type BlobFactory func(params map[string]string) (BlobStore, error)
+
+var (
+	blobStoresMu sync.RWMutex
+	blobStores   = make(map[string]BlobFactory)
+)
+
+// RegisterBlobStore makes a store available under the given blobtype name.
+// It panics if the name is registered twice.
+func RegisterBlobStore(name string, factory BlobFactory) {
+	blobStoresMu.Lock()
+	defer blobStoresMu.Unlock()
+	if factory == nil {
+		panic("ext: RegisterBlobStore factory is nil")
+	}
+	if _, dup := blobStores[name]; dup {
+		panic("ext: RegisterBlobStore called twice for store " + name)
+	}
+	blobStores[name] = factory
+}
+
+// NewBlobStore builds the store registered under name.
+func NewBlobStore(name string, params map[string]string) (BlobStore, error) {
+	blobStoresMu.RLock()
+	factory, ok := blobStores[name]
+	blobStoresMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("ext: unknown blob store %q", name)
+	}
+	return factory(params)
+}
+
+// BlobStores lists the registered store names.
+func BlobStores() []string {
+	blobStoresMu.RLock()
+	defer blobStoresMu.RUnlock()
+	names := make([]string, 0, len(blobStores))
+	for name := range blobStores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,189 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	s "strings"
+	"time"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+const (
+	darkSkyURL      = "https://api.darksky.net/forecast"
+	darkSkyInterval = 900
+)
+
+type darkSky struct {
+	key      string
+	baseURL  string
+	units    string
+	interval time.Duration
+	client   *http.Client
+}
+
+type darkSkyForecast struct {
+	Currently darkSkyPoint `json:"currently"`
+	Daily     struct {
+		Data []darkSkyDay `json:"data"`
+	} `json:"daily"`
+}
+
+type darkSkyPoint struct {
+	Time                int64   `json:"time"`
+	Temperature         float64 `json:"temperature"`
+	ApparentTemperature float64 `json:"apparentTemperature"`
+	CloudCover          float64 `json:"cloudCover"`
+	Humidity            float64 `json:"humidity"`
+	Pressure            float64 `json:"pressure"`
+	PrecipProbability   float64 `json:"precipProbability"`
+}
+
+type darkSkyDay struct {
+	Time        int64 `json:"time"`
+	SunriseTime int64 `json:"sunriseTime"`
+	SunsetTime  int64 `json:"sunsetTime"`
+}
+
+func init() {
+	RegisterWeather("darksky", newDarkSkyFromParams)
+}
+
+// NewDarkSky returns a provider for the DarkSky forecast API using the
+// default endpoint, SI units and polling interval.
+func NewDarkSky(key string) *darkSky {
+	return &darkSky{
+		key:      key,
+		baseURL:  darkSkyURL,
+		units:    "si",
+		interval: darkSkyInterval * time.Second,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// newDarkSkyFromParams reads the weatherparams config map. Besides the
+// required key it accepts url (any DarkSky-compatible endpoint), units and
+// interval (seconds between polls).
+func newDarkSkyFromParams(params map[string]string) (Weather, error) {
+	key := params["key"]
+	if key == "" {
+		return nil, errors.New("ext: darksky requires weatherparams.key")
+	}
+	d := NewDarkSky(key)
+	if u, ok := params["url"]; ok && u != "" {
+		d.baseURL = s.TrimRight(u, "/")
+	}
+	if units, ok := params["units"]; ok && units != "" {
+		d.units = units
+	}
+	if interval, ok := params["interval"]; ok && interval != "" {
+		secs, err := strconv.ParseInt(interval, 10, 64)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("ext: bad darksky interval %q", interval)
+		}
+		d.interval = time.Duration(secs) * time.Second
+	}
+	return d, nil
+}
+
+func (d *darkSky) DoFetch(k kb.KB, done chan bool) {
+	if err := d.Fetch(k); err != nil {
+		log.Println(err)
+	}
+	tick := time.NewTicker(d.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := d.Fetch(k); err != nil {
+				log.Println(err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (d *darkSky) Fetch(k kb.KB) error {
+	coords, l_ids, ok := k.GetCoordinates()
+	if !ok {
+		return nil
+	}
+	failed := 0
+	for i, pair := range coords {
+		fc, err := d.forecast(pair[0], pair[1])
+		if err != nil {
+			log.Printf("darksky location %d: %v\n", l_ids[i], err)
+			failed++
+			continue
+		}
+		cur := fc.Currently
+		if !k.AddWeather(l_ids[i], cur.Time, fc.sunUp(), cur.Temperature, cur.ApparentTemperature, cur.CloudCover, cur.Humidity, cur.Pressure, cur.PrecipProbability) {
+			log.Printf("darksky location %d: could not store weather\n", l_ids[i])
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("darksky: %d of %d locations failed", failed, len(coords))
+	}
+	return nil
+}
+
+func (d *darkSky) forecast(lat, lon string) (*darkSkyForecast, error) {
+	q := url.Values{}
+	q.Set("exclude", "minutely,hourly,alerts,flags")
+	q.Set("units", d.units)
+	u := fmt.Sprintf("%s/%s/%s,%s?%s", d.baseURL, url.PathEscape(d.key), url.PathEscape(lat), url.PathEscape(lon), q.Encode())
+
+	resp, err := d.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	fc := new(darkSkyForecast)
+	if err = json.NewDecoder(resp.Body).Decode(fc); err != nil {
+		return nil, err
+	}
+	if fc.Currently.Time == 0 {
+		return nil, errors.New("response has no current conditions")
+	}
+	return fc, nil
+}
+
+// sunUp reports whether the current observation falls between sunrise and
+// sunset of the day it belongs to.
+func (fc *darkSkyForecast) sunUp() bool {
+	now := fc.Currently.Time
+	for _, day := range fc.Daily.Data {
+		if day.SunriseTime == 0 && day.SunsetTime == 0 {
+			continue
+		}
+		if now >= day.SunriseTime && now < day.SunsetTime {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"net/http"
+	"net/http/httptest"
+	s "strings"
+	"testing"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// weatherKB is the part of a KB a weather provider uses. Any other method
+// panics.
+type weatherKB struct {
+	kb.KB
+	coords  [][]string
+	ids     []int64
+	stored  map[int64]storedWeather
+	storeOK bool
+}
+
+type storedWeather struct {
+	timestamp   int64
+	sunUp       bool
+	temperature float64
+	humidity    float64
+}
+
+func (k *weatherKB) GetCoordinates() ([][]string, []int64, bool) {
+	return k.coords, k.ids, true
+}
+
+func (k *weatherKB) AddWeather(location, timestamp int64, sunUp bool, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability float64) bool {
+	k.stored[location] = storedWeather{timestamp, sunUp, temperature, humidity}
+	return k.storeOK
+}
+
+const forecastBody = `{
+	"currently": {"time": 1500000000, "temperature": 21.5, "apparentTemperature": 21, "cloudCover": 0.2,
+		"humidity": 0.5, "pressure": 1013, "precipProbability": 0.1},
+	"daily": {"data": [{"time": 1499990000, "sunriseTime": 1499995000, "sunsetTime": 1500040000}]}
+}`
+
+const nightBody = `{
+	"currently": {"time": 1500050000, "temperature": 12},
+	"daily": {"data": [{"time": 1499990000, "sunriseTime": 1499995000, "sunsetTime": 1500040000}]}
+}`
+
+func newStandIn(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /<key>/<lat>,<lon>
+		parts := s.Split(s.TrimPrefix(r.URL.Path, "/"), "/")
+		if len(parts) != 2 || parts[0] != "secret" {
+			http.Error(w, "bad key", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("units") != "si" {
+			t.Errorf("units = %q, want si", r.URL.Query().Get("units"))
+		}
+		switch parts[1] {
+		case "1.5,2.5":
+			w.Write([]byte(forecastBody))
+		case "3,4":
+			w.Write([]byte(nightBody))
+		case "5,6":
+			w.Write([]byte(`{"daily": {}}`))
+		default:
+			w.Write([]byte(`not json`))
+		}
+	}))
+}
+
+func standInDarkSky(t *testing.T, url, key string) Weather {
+	w, err := NewWeather("darksky", map[string]string{"key": key, "url": url + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestDarkSkyFetch(t *testing.T) {
+	srv := newStandIn(t)
+	defer srv.Close()
+
+	k := &weatherKB{
+		coords:  [][]string{{"1.5", "2.5"}, {"3", "4"}},
+		ids:     []int64{10, 20},
+		stored:  make(map[int64]storedWeather),
+		storeOK: true,
+	}
+	if err := standInDarkSky(t, srv.URL, "secret").Fetch(k); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]storedWeather{
+		10: {1500000000, true, 21.5, 0.5},
+		20: {1500050000, false, 12, 0},
+	}
+	for id, w := range want {
+		if got := k.stored[id]; got != w {
+			t.Errorf("location %d: stored %+v, want %+v", id, got, w)
+		}
+	}
+}
+
+func TestDarkSkyFetchFailures(t *testing.T) {
+	srv := newStandIn(t)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		key     string
+		coords  [][]string
+		storeOK bool
+		stored  int
+	}{
+		{"bad key", "wrong", [][]string{{"1.5", "2.5"}}, true, 0},
+		{"no current conditions", "secret", [][]string{{"5", "6"}}, true, 0},
+		{"not json", "secret", [][]string{{"7", "8"}, {"1.5", "2.5"}}, true, 1},
+		{"store fails", "secret", [][]string{{"1.5", "2.5"}}, false, 1},
+	}
+	for _, tt := range tests {
+		k := &weatherKB{coords: tt.coords, stored: make(map[int64]storedWeather), storeOK: tt.storeOK}
+		for i := range tt.coords {
+			k.ids = append(k.ids, int64(i+1))
+		}
+		err := standInDarkSky(t, srv.URL, tt.key).Fetch(k)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+		if len(k.stored) != tt.stored {
+			t.Errorf("%s: stored %d, want %d", tt.name, len(k.stored), tt.stored)
+		}
+	}
+}
+
+func TestDarkSkyParams(t *testing.T) {
+	for _, params := range []map[string]string{
+		{},
+		{"key": "k", "interval": "0"},
+		{"key": "k", "interval": "soon"},
+	} {
+		if _, err := NewWeather("darksky", params); err == nil {
+			t.Errorf("params %v: no error", params)
+		}
+	}
+	w, err := NewWeather("darksky", map[string]string{"key": "k", "url": "http://localhost/", "units": "us", "interval": "60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := w.(*darkSky)
+	if d.baseURL != "http://localhost" || d.units != "us" || d.interval.Seconds() != 60 {
+		t.Errorf("got %+v", d)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	s "strings"
+)
+
+const fileBlobDir = "blobs"
+
+// fileBlobs keeps every blob in a file of its own below dir.
+type fileBlobs struct {
+	dir string
+}
+
+func init() {
+	RegisterBlobStore("file", newFileBlobsFromParams)
+}
+
+// NewFileBlobs returns a store keeping blobs below dir, which is created
+// when the first blob is stored.
+func NewFileBlobs(dir string) *fileBlobs {
+	return &fileBlobs{dir: dir}
+}
+
+// newFileBlobsFromParams reads the blobparams config map, whose dir
+// defaults to blobs in the working directory.
+func newFileBlobsFromParams(params map[string]string) (BlobStore, error) {
+	dir := params["dir"]
+	if dir == "" {
+		dir = fileBlobDir
+	}
+	return NewFileBlobs(dir), nil
+}
+
+// path maps a key to its file, refusing keys that would leave dir.
+func (f *fileBlobs) path(key string) (string, error) {
+	if key == "" || s.HasPrefix(key, "/") {
+		return "", fmt.Errorf("ext: bad blob key %q", key)
+	}
+	for _, part := range s.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("ext: bad blob key %q", key)
+		}
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first, so a blob is either
+// stored whole or not at all.
+func (f *fileBlobs) Put(key string, data []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (f *fileBlobs) Get(key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+func (f *fileBlobs) Delete(key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"lachut.net/gogs/dslachut/go-irleak/kb"
+)
+
+// Weather is a source of outdoor conditions for the locations in the KB.
+type Weather interface {
+	// Fetch polls the current conditions for every location once and
+	// stores them with kb.KB.AddWeather.
+	Fetch(k kb.KB) error
+	// DoFetch calls Fetch periodically until done is closed.
+	DoFetch(k kb.KB, done chan bool)
+}
+
+// WeatherFactory builds a Weather provider from the weatherparams config map.
+type WeatherFactory func(params map[string]string) (Weather, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]WeatherFactory)
+)
+
+// RegisterWeather makes a provider available under the given weathertype
+// name. It panics if the name is registered twice.
+func RegisterWeather(name string, factory WeatherFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if factory == nil {
+		panic("ext: RegisterWeather factory is nil")
+	}
+	if _, dup := providers[name]; dup {
+		panic("ext: RegisterWeather called twice for provider " + name)
+	}
+	providers[name] = factory
+}
+
+// NewWeather builds the provider registered under name.
+func NewWeather(name string, params map[string]string) (Weather, error) {
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("ext: unknown weather provider %q", name)
+	}
+	return factory(params)
+}
+
+// WeatherProviders lists the registered provider names.
+func WeatherProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,162 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kb
+
+import (
+	"math"
+	"sort"
+)
+
+// MaxAlignedRows bounds the size of the grid GetAlignedSeries will build.
+const MaxAlignedRows = 100000
+
+// Fill selects how a series is resampled onto the common time grid.
+type Fill int
+
+const (
+	// FillLinear interpolates between the neighbouring samples.
+	FillLinear Fill = iota
+	// FillPrevious carries the last sample forward.
+	FillPrevious
+)
+
+// AlignedSeries holds indoor temperatures and the outdoor temperature of a
+// location resampled onto the same evenly spaced timestamps. Grid points
+// with no data on either side are NaN.
+type AlignedSeries struct {
+	Interval float64
+	Sensors  []string
+	Rows     []AlignedRow
+}
+
+// AlignedRow is one grid point; Indoor is ordered like AlignedSeries.Sensors.
+// SunUp is carried forward from the latest weather observation.
+type AlignedRow struct {
+	Timestamp float64
+	SunUp     bool
+	Outdoor   float64
+	Indoor    []float64
+}
+
+type sample struct {
+	t, v float64
+}
+
+// alignSeries does the work of GetAlignedSeries for every back-end. When no
+// sensors are given all of the user's sensors in the range are used. A
+// name household members' sensors share stands for each of them, and the
+// series are labelled as by SensorRef.Label.
+func alignSeries(k KB, user string, location int64, sensors []string, start, end, interval float64, fill Fill) (*AlignedSeries, bool) {
+	if interval <= 0 || end < start {
+		return nil, false
+	}
+	refs := k.GetTemperatureRefs(user, start, end)
+	if len(sensors) > 0 {
+		named := make([]SensorRef, 0, len(sensors))
+		for _, sensor := range sensors {
+			found := false
+			for _, ref := range refs {
+				if ref.Sensor == sensor {
+					named = append(named, ref)
+					found = true
+				}
+			}
+			if !found {
+				named = append(named, SensorRef{Owner: user, Sensor: sensor})
+			}
+		}
+		refs = named
+	}
+
+	weather := k.GetWeather(location, start, end)
+	outdoor := make([]sample, 0, len(weather))
+	sun := make([]sample, 0, len(weather))
+	for _, obs := range weather {
+		outdoor = append(outdoor, sample{obs.Timestamp, obs.Temperature})
+		if obs.SunUp {
+			sun = append(sun, sample{obs.Timestamp, 1})
+		} else {
+			sun = append(sun, sample{obs.Timestamp, 0})
+		}
+	}
+	sortSamples(outdoor)
+	sortSamples(sun)
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	extend := func(series []sample) {
+		if len(series) > 0 {
+			lo = math.Min(lo, series[0].t)
+			hi = math.Max(hi, series[len(series)-1].t)
+		}
+	}
+	extend(outdoor)
+	labels := make([]string, 0, len(refs))
+	indoor := make([][]sample, 0, len(refs))
+	for _, ref := range refs {
+		labels = append(labels, ref.Label(user))
+		temps := k.GetSensorTemperatures(user, ref, start, end)
+		series := make([]sample, 0, len(temps))
+		for t, v := range temps {
+			series = append(series, sample{t, v})
+		}
+		sortSamples(series)
+		extend(series)
+		indoor = append(indoor, series)
+	}
+
+	out := &AlignedSeries{Interval: interval, Sensors: labels, Rows: []AlignedRow{}}
+	if lo > hi {
+		return out, true
+	}
+	first := math.Ceil(lo/interval) * interval
+	if (hi-first)/interval >= MaxAlignedRows {
+		return nil, false
+	}
+	for t := first; t <= hi; t += interval {
+		row := AlignedRow{
+			Timestamp: t,
+			SunUp:     resample(sun, t, FillPrevious) == 1,
+			Outdoor:   resample(outdoor, t, fill),
+			Indoor:    make([]float64, len(indoor)),
+		}
+		for i, series := range indoor {
+			row.Indoor[i] = resample(series, t, fill)
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	return out, true
+}
+
+func sortSamples(series []sample) {
+	sort.Slice(series, func(i, j int) bool { return series[i].t < series[j].t })
+}
+
+// resample evaluates a sorted series at t.
+func resample(series []sample, t float64, fill Fill) float64 {
+	// i is the first sample after t
+	i := sort.Search(len(series), func(i int) bool { return series[i].t > t })
+	if i == 0 {
+		return math.NaN()
+	}
+	prev := series[i-1]
+	if prev.t == t || fill == FillPrevious {
+		return prev.v
+	}
+	if i == len(series) {
+		return math.NaN()
+	}
+	next := series[i]
+	return prev.v + (next.v-prev.v)*(t-prev.t)/(next.t-prev.t)
+}
@@ -0,0 +1,100 @@
+// Copyright © 2017 David Lachut <dslachut@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kb
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// UserArchive is everything stored for one user, as removed by DeleteUser.
+// Password hashes and tokens are left out, and so is data other household
+// members share with the user.
+type UserArchive struct {
+	Exported     int64                   `json:"exported"`
+	Account      Account                 `json:"account"`
+	APIKeys      []APIKey                `json:"api_keys"`
+	Sensors      []Sensor                `json:"sensors"`
+	Calibrations []Calibration           `json:"calibrations"`
+	Locations    []LocationArchive       `json:"locations"`
+	Temperatures map[string][][2]float64 `json:"temperatures"`
+	Measurements []Measurement           `json:"measurements"`
+	Frames       []ThermalFrame          `json:"thermal_frames"`
+}
+
+// LocationArchive is a location with all of its weather.
+type LocationArchive struct {
+	Location
+	Weather []Weather `json:"weather"`
+}
+
+// ExportUser collects a user's data from any back-end. Temperatures are
+// [timestamp, value] pairs keyed by sensor, Measurements holds all other
+// kinds. Of thermal frames only the statistics are included, not pixels.
+func ExportUser(k KB, user string) (*UserArchive, bool) {
+	account, ok := k.GetAccount(user)
+	if !ok {
+		return nil, false
+	}
+	archive := &UserArchive{
+		Exported:     time.Now().Unix(),
+		Account:      account,
+		APIKeys:      k.ListAPIKeys(user),
+		Sensors:      make([]Sensor, 0),
+		Calibrations: k.ListCalibrations(user, ""),
+		Locations:    make([]LocationArchive, 0),
+		Temperatures: make(map[string][][2]float64),
+		Measurements: make([]Measurement, 0),
+		Frames:       make([]ThermalFrame, 0),
+	}
+
+	for _, sensor := range k.ListSensors(user) {
+		if sensor.Owner == user {
+			archive.Sensors = append(archive.Sensors, sensor)
+		}
+	}
+
+	for _, loc := range k.ListLocations(user) {
+		if loc.Owner != user {
+			continue
+		}
+		archive.Locations = append(archive.Locations, LocationArchive{
+			Location: loc,
+			Weather:  k.GetWeather(loc.ID, -math.MaxFloat64, math.MaxFloat64),
+		})
+	}
+
+	for sensor, temps := range k.GetUserTemperatures(user) {
+		points := make([][2]float64, 0, len(temps))
+		for ts, value := range temps {
+			points = append(points, [2]float64{ts, value})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i][0] < points[j][0] })
+		archive.Temperatures[sensor] = points
+	}
+
+	for _, m := range k.GetMeasurements(user, "", "", -math.MaxFloat64, math.MaxFloat64) {
+		if m.Owner == user && m.Kind != KindTemperature {
+			archive.Measurements = append(archive.Measurements, m)
+		}
+	}
+	for _, frame := range k.GetThermalFrames(user, "", -math.MaxFloat64, math.MaxFloat64) {
+		if frame.Owner == user {
+			archive.Frames = append(archive.Frames, frame)
+		}
+	}
+	return archive, true
+}
@@ -16,24 +16,390 @@ package kb
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
+	"strconv"
+	s "strings"
 )
 
 type KB interface {
 	Stop()
 	GetHash(user string) ([]byte, bool)
-	AddToken(user string, token string, expiration int64) bool
+	AddToken(user string, token string, expiration int64, session string) bool
 	AddUser(user string, hash string) bool
-	GetUser(token string) (string, int64, bool)
+	GetUser(token string) (string, int64, string, bool)
+	GetAccount(user string) (Account, bool)
+	ListAccounts() []Account
+	SetHash(user, hash string) bool
+	SetRole(user, role string) bool
+	SetDisabled(user string, disabled bool) bool
+	DeleteUser(user string) bool
 	ExpireToken(token string) bool
 	PurgeTokens(expiration int64) bool
+	AddSession(session Session) bool
+	TouchSession(id string, expiration, lastUsed int64, clientIP string) bool
+	GetSession(id string) (Session, bool)
+	ListSessions(user string, now int64) []Session
+	RevokeSession(user, id string) bool
+	AddRevoked(tokenID string, expiration int64) bool
+	GetRevoked(now int64) (map[string]int64, bool)
+	AddAudit(event AuditEvent) bool
+	GetAudit(user string, start, end int64) []AuditEvent
+	GetLoginFailure(kind, subject string) (LoginFailure, bool)
+	ListLoginFailures() []LoginFailure
+	SetLoginFailure(f LoginFailure) bool
+	ClearLoginFailure(kind, subject string) bool
+	AddAPIKey(user string, key APIKey) bool
+	GetAPIKey(id string) (APIKey, bool)
+	ListAPIKeys(user string) []APIKey
+	RevokeAPIKey(user, id string, when int64) bool
+	AddUpload(upload Upload) bool
+	GetUpload(tokenHash, key string) (Upload, bool)
+	TakeOverUpload(upload Upload, started int64) bool
+	FinishUpload(upload Upload) bool
+	DeleteUpload(tokenHash, key string) bool
 
+	AddMeasurement(m Measurement) bool
+	AddMeasurements(ms []Measurement) (int64, bool)
+	GetMeasurements(user, sensor, kind string, start, end float64) []Measurement
 	AddTemperature(user, sensor string, timestamp, value float64) bool
+	AddTemperatures(user, sensor string, readings []Reading) (int64, bool)
 	AddWeather(location, timestamp int64, sunUp bool, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability float64) bool
-	GetTemperatures(user, sensor string, start, end float64) map[float64]float64
-	GetTemperatureSensors(user string, start, end float64) []string
+	GetWeather(location int64, start, end float64) []Weather
+	GetSensorTemperatures(user string, ref SensorRef, start, end float64) map[float64]float64
+	GetTemperatureRefs(user string, start, end float64) []SensorRef
+	GetUserTemperatures(user string) map[string]map[float64]float64
+	GetAlignedSeries(user string, location int64, sensors []string, start, end, interval float64, fill Fill) (*AlignedSeries, bool)
+
+	AddThermalFrame(frame ThermalFrame) (int64, bool)
+	GetThermalFrame(user string, id int64) (ThermalFrame, bool)
+	GetThermalFrames(user, sensor string, start, end float64) []ThermalFrame
+	DeleteThermalFrame(user string, id int64) bool
+
+	AddSensor(sensor Sensor) bool
+	GetSensor(user, sensor string) (Sensor, bool)
+	ListSensors(user string) []Sensor
+	UpdateSensor(sensor Sensor) bool
+	DeleteSensor(user, sensor string) bool
+
+	AddCalibration(cal Calibration) (int64, bool)
+	ListCalibrations(user, sensor string) []Calibration
+	DeleteCalibration(user string, id int64) bool
 
 	GetCoordinates() ([][]string, []int64, bool)
+	AddLocation(user, placeName, lat, lon string) (int64, bool)
+	GetLocation(user string, id int64) (Location, bool)
+	ListLocations(user string) []Location
+	UpdateLocation(user string, id int64, placeName, lat, lon string) bool
+	DeleteLocation(user string, id int64) bool
+	SetLocationHousehold(user string, location, household int64) bool
+
+	AddHousehold(user, name string) (int64, bool)
+	GetHousehold(id int64) (Household, bool)
+	ListHouseholds(user string) []Household
+	DeleteHousehold(id int64) bool
+	GetMembership(id int64, user string) (string, bool)
+	SetMember(id int64, user, permission string) bool
+	RemoveMember(id int64, user string) bool
+	ListMembers(id int64) []HouseholdMember
+	AddHouseholdSensor(id int64, user, sensor string) bool
+	RemoveHouseholdSensor(id int64, user, sensor string) bool
+	ListHouseholdSensors(id int64) []HouseholdSensor
+}
+
+// Roles a user may have.
+const (
+	RoleAdmin    = "admin"
+	RoleUser     = "user"
+	RoleReadOnly = "read-only"
+	RoleDevice   = "device"
+)
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleUser, RoleReadOnly, RoleDevice:
+		return true
+	}
+	return false
+}
+
+// Account is a user's login, without the password hash.
+type Account struct {
+	User     string `json:"user"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+// Session follows one login through all the tokens issued from it.
+// LastUsed and ClientIP are those of the latest request that rotated or
+// reissued its token.
+type Session struct {
+	ID       string `json:"id"`
+	User     string `json:"user"`
+	Created  int64  `json:"created"`
+	Expires  int64  `json:"expires"`
+	LastUsed int64  `json:"last_used"`
+	ClientIP string `json:"client_ip"`
+}
+
+// Audited actions.
+const (
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditLogout         = "logout"
+	AuditSessionRevoked = "session_revoked"
+	AuditKeyCreated     = "apikey_created"
+	AuditKeyRevoked     = "apikey_revoked"
+	AuditUserCreated    = "user_created"
+	AuditUserChanged    = "user_changed"
+	AuditUserDeleted    = "user_deleted"
+	AuditPassword       = "password_changed"
+	AuditUpload         = "upload"
+	AuditDelete         = "delete"
+)
+
+// AuditEvent records who did what to whom, when and from where. Events are
+// only ever added, never changed or removed, not even with their user.
+type AuditEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Subject   string `json:"subject"`
+	ClientIP  string `json:"client_ip"`
+	Detail    string `json:"detail"`
+}
+
+// Kinds of subject whose failed logins are counted.
+const (
+	FailureUser = "user"
+	FailureIP   = "ip"
+)
+
+// LoginFailure counts the recent failed logins of a user name or client
+// address and how long it is locked out for.
+type LoginFailure struct {
+	Kind        string
+	Subject     string
+	Failures    int64
+	LastFailure int64
+	LockedUntil int64
+}
+
+// APIKey is a long-lived credential for an unattended device. Only a hash
+// of the secret part is stored. A key with no Sensors may upload for any of
+// its user's sensors.
+type APIKey struct {
+	ID      string   `json:"id"`
+	User    string   `json:"user"`
+	Name    string   `json:"name"`
+	Hash    string   `json:"-"`
+	Sensors []string `json:"sensors"`
+	Created int64    `json:"created"`
+	Revoked int64    `json:"revoked,omitempty"`
+}
+
+// Upload remembers the answer to an upload sent with an idempotency key, so
+// that a retry gets the same answer. It is found by the key and a hash of
+// the token the upload came with. Status is 0 while the attempt that
+// claimed it, at Started, is still being handled.
+type Upload struct {
+	Key       string
+	TokenHash string
+	User      string
+	Expires   int64
+	Started   int64
+	Status    int64
+	NewToken  string
+	Response  string
+}
+
+// Location is a named place where a user's devices live. Coordinates are kept
+// as the decimal strings they are stored and sent to weather providers as.
+type Location struct {
+	ID        int64  `json:"id"`
+	Owner     string `json:"owner,omitempty"`
+	Household int64  `json:"household,omitempty"`
+	PlaceName string `json:"place_name"`
+	Lat       string `json:"lat"`
+	Lon       string `json:"lon"`
+}
+
+// Kinds of quantity a sensor may measure. Temperatures are measurements of
+// KindTemperature.
+const (
+	KindTemperature        = "temperature"
+	KindSurfaceTemperature = "surface_temperature"
+	KindHumidity           = "humidity"
+	KindPower              = "power"
+	KindEnergy             = "energy"
+	KindHVACState          = "hvac_state"
+)
+
+var kindUnits = map[string]string{
+	KindTemperature:        "C",
+	KindSurfaceTemperature: "C",
+	KindHumidity:           "%",
+	KindPower:              "W",
+	KindEnergy:             "Wh",
+	KindHVACState:          "state",
+}
+
+// DefaultUnit returns the unit measurements of kind are in unless another
+// one is given, and whether kind is one of the known kinds.
+func DefaultUnit(kind string) (string, bool) {
+	unit, ok := kindUnits[kind]
+	return unit, ok
+}
+
+// Reading is one value of a sensor's series.
+type Reading struct {
+	Timestamp float64 `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// SensorRef names a sensor. Sensor names are only unique per user, so a
+// sensor shared with a household is told apart by its owner.
+type SensorRef struct {
+	Owner  string `json:"owner"`
+	Sensor string `json:"sensor"`
+}
+
+// Label names the sensor for the user: by name if it is their own,
+// otherwise as owner/name.
+func (ref SensorRef) Label(user string) string {
+	if ref.Owner == user {
+		return ref.Sensor
+	}
+	return ref.Owner + "/" + ref.Sensor
+}
+
+// Measurement is one reading of a quantity by a sensor.
+type Measurement struct {
+	Owner     string  `json:"owner"`
+	Sensor    string  `json:"sensor"`
+	Kind      string  `json:"kind"`
+	Unit      string  `json:"unit"`
+	Timestamp float64 `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// ThermalFrame describes an infrared image taken by a sensor. Its pixels
+// are kept in a blob store under Blob; the KB only holds their size and
+// the lowest, highest and mean temperature.
+type ThermalFrame struct {
+	ID        int64   `json:"id"`
+	Owner     string  `json:"owner"`
+	Sensor    string  `json:"sensor"`
+	Timestamp float64 `json:"timestamp"`
+	Width     int64   `json:"width"`
+	Height    int64   `json:"height"`
+	Blob      string  `json:"-"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Mean      float64 `json:"mean"`
+}
+
+// Surfaces a sensor may be mounted on.
+const (
+	SurfaceWall    = "wall"
+	SurfaceWindow  = "window"
+	SurfaceCeiling = "ceiling"
+	SurfaceFloor   = "floor"
+	SurfaceDoor    = "door"
+)
+
+// Sides of the surface a sensor may face.
+const (
+	SideInterior = "interior"
+	SideExterior = "exterior"
+)
+
+// ValidPlacement reports whether surface and side are known, or left empty.
+func ValidPlacement(surface, side string) bool {
+	switch surface {
+	case "", SurfaceWall, SurfaceWindow, SurfaceCeiling, SurfaceFloor, SurfaceDoor:
+	default:
+		return false
+	}
+	return side == "" || side == SideInterior || side == SideExterior
+}
+
+// Sensor describes where and what a sensor is. Name is the sensor string
+// readings are uploaded under and Owner the user uploading them. Location
+// is 0 when unknown, Removed is 0 while the sensor is still installed.
+type Sensor struct {
+	Owner             string  `json:"owner"`
+	Name              string  `json:"sensor"`
+	DisplayName       string  `json:"display_name"`
+	Room              string  `json:"room"`
+	Surface           string  `json:"surface"`
+	Side              string  `json:"side"`
+	Location          int64   `json:"location"`
+	Model             string  `json:"model"`
+	CalibrationOffset float64 `json:"calibration_offset"`
+	Installed         int64   `json:"installed"`
+	Removed           int64   `json:"removed"`
+}
+
+// Calibration corrects a sensor's readings from Effective on, until the
+// next calibration of the same sensor takes over. Stored readings stay raw.
+type Calibration struct {
+	ID        int64   `json:"id"`
+	Owner     string  `json:"owner"`
+	Sensor    string  `json:"sensor"`
+	Effective float64 `json:"effective"`
+	Gain      float64 `json:"gain"`
+	Offset    float64 `json:"offset"`
+}
+
+// Apply returns the calibrated value of a raw reading.
+func (c Calibration) Apply(value float64) float64 {
+	return c.Gain*value + c.Offset
+}
+
+// Permissions of a household member. Each one includes those before it:
+// readers see the household's data, managers share their own sensors and
+// locations with it, and admins also manage the members.
+const (
+	HouseholdRead   = "read"
+	HouseholdManage = "manage"
+	HouseholdAdmin  = "admin"
+)
+
+var householdRank = map[string]int{HouseholdRead: 1, HouseholdManage: 2, HouseholdAdmin: 3}
+
+// ValidHouseholdPermission reports whether p is one of the known member
+// permissions.
+func ValidHouseholdPermission(p string) bool {
+	return householdRank[p] != 0
+}
+
+// HouseholdAllows reports whether a member with permission have may do what
+// needs permission want.
+func HouseholdAllows(have, want string) bool {
+	return householdRank[have] != 0 && householdRank[have] >= householdRank[want]
+}
+
+// Household groups the people with access to a home's sensors and
+// locations. Permission is the listing user's own permission.
+type Household struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Created    int64  `json:"created"`
+	Permission string `json:"permission,omitempty"`
+}
+
+type HouseholdMember struct {
+	User       string `json:"user"`
+	Permission string `json:"permission"`
+}
+
+// HouseholdSensor is a sensor a member shares with their household.
+type HouseholdSensor struct {
+	User   string `json:"user"`
+	Sensor string `json:"sensor"`
 }
 
 type query struct {
@@ -41,6 +407,49 @@ type query struct {
 	arguments   []interface{}
 	rows        chan []map[string]interface{}
 	result      chan sql.Result
+	batch       []*query
+	batchArgs   [][]interface{}
+	// mustAffect rolls back the transaction a batch query is part of when
+	// it matches no rows.
+	mustAffect bool
+}
+
+// migrate applies schema changes that CREATE TABLE IF NOT EXISTS can't make
+// to an existing database. Columns that are already there are skipped.
+func migrate(db *sql.DB, stmts []string) {
+	for _, stmt := range stmts {
+		_, err := db.Exec(stmt)
+		if err != nil && !s.Contains(s.ToLower(err.Error()), "duplicate column") {
+			log.Println(stmt)
+			log.Fatal(err)
+		}
+	}
+}
+
+// moveTable moves the rows of a table that has been replaced into its
+// replacement and renames it out of the way, if exists finds it still
+// there.
+func moveTable(db *sql.DB, exists string, stmts []string) {
+	var name string
+	if db.QueryRow(exists).Scan(&name) != nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, stmt := range stmts {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			tx.Rollback()
+			log.Println(stmt)
+			log.Fatal(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func doInsert(db *sql.DB, q *query) {
@@ -63,6 +472,119 @@ func doInsert(db *sql.DB, q *query) {
 	close(q.result)
 }
 
+// doTx runs every query of q.batch in one transaction and sends the result
+// of the last one. Nothing is committed if any of them fails, or matches
+// no rows when it must.
+func doTx(db *sql.DB, q *query) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println(err)
+		close(q.result)
+		return
+	}
+
+	var res sql.Result
+	for _, b := range q.batch {
+		res, err = tx.Exec(b.queryString, b.arguments...)
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			close(q.result)
+			return
+		}
+		if b.mustAffect {
+			if n, err := res.RowsAffected(); err != nil || n == 0 {
+				tx.Rollback()
+				close(q.result)
+				return
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println(err)
+		close(q.result)
+		return
+	}
+	q.result <- res
+	close(q.result)
+}
+
+// batchResult is the number of rows a whole batch affected.
+type batchResult int64
+
+func (r batchResult) LastInsertId() (int64, error) {
+	return 0, errors.New("kb: no last insert id for a batch")
+}
+
+func (r batchResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+// multiRowInsert repeats the VALUES tuple that ends an INSERT statement so
+// that it inserts rows rows at once.
+func multiRowInsert(queryString string, rows int) string {
+	tuple := queryString[s.LastIndex(queryString, "("):]
+	return queryString + s.Repeat(", "+tuple, rows-1)
+}
+
+// doBatch runs q.queryString with every argument list of q.batchArgs in one
+// transaction and sends the number of rows they affected in all. Nothing is
+// committed if any of them fails. The statement is prepared once and
+// reused; argument lists holding several rows for an INSERT run as one
+// multi-row INSERT.
+func doBatch(db *sql.DB, q *query) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println(err)
+		close(q.result)
+		return
+	}
+
+	width := s.Count(q.queryString[s.LastIndex(q.queryString, "("):], "?")
+	stmts := make(map[int]*sql.Stmt)
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+
+	var n int64
+	for _, args := range q.batchArgs {
+		stmt, ok := stmts[len(args)]
+		if !ok {
+			queryString := q.queryString
+			if len(args) > width {
+				queryString = multiRowInsert(queryString, len(args)/width)
+			}
+			if stmt, err = tx.Prepare(queryString); err != nil {
+				log.Println(err)
+				tx.Rollback()
+				close(q.result)
+				return
+			}
+			stmts[len(args)] = stmt
+		}
+		res, err := stmt.Exec(args...)
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			close(q.result)
+			return
+		}
+		affected, _ := res.RowsAffected()
+		n += affected
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println(err)
+		close(q.result)
+		return
+	}
+	q.result <- batchResult(n)
+	close(q.result)
+}
+
 func doQuery(db *sql.DB, q *query) {
 	stmt, err := db.Prepare(q.queryString)
 	if err != nil {
@@ -104,3 +626,237 @@ func doQuery(db *sql.DB, q *query) {
 	q.rows <- outRows
 	close(q.rows)
 }
+
+// Weather is one observation of outdoor conditions at a location.
+type Weather struct {
+	Timestamp           float64 `json:"timestamp"`
+	SunUp               bool    `json:"sun_up"`
+	Temperature         float64 `json:"temperature"`
+	ApparentTemperature float64 `json:"apparent_temperature"`
+	CloudCover          float64 `json:"cloud_cover"`
+	Humidity            float64 `json:"humidity"`
+	Pressure            float64 `json:"pressure"`
+	PrecipProbability   float64 `json:"precip_probability"`
+}
+
+func rowAccount(row map[string]interface{}, userCol string) Account {
+	return Account{
+		User:     rowString(row[userCol]),
+		Role:     rowString(row["role"]),
+		Disabled: rowBool(row["disabled"]),
+	}
+}
+
+func rowSession(row map[string]interface{}, userCol string) Session {
+	return Session{
+		ID:       rowString(row["s_id"]),
+		User:     rowString(row[userCol]),
+		Created:  rowInt(row["created"]),
+		Expires:  rowInt(row["exp"]),
+		LastUsed: rowInt(row["last_used"]),
+		ClientIP: rowString(row["client_ip"]),
+	}
+}
+
+func rowAuditEvent(row map[string]interface{}, userCol string) AuditEvent {
+	return AuditEvent{
+		Timestamp: rowInt(row["timestamp"]),
+		User:      rowString(row[userCol]),
+		Action:    rowString(row["action"]),
+		Subject:   rowString(row["subject"]),
+		ClientIP:  rowString(row["client_ip"]),
+		Detail:    rowString(row["detail"]),
+	}
+}
+
+func rowLoginFailure(row map[string]interface{}) LoginFailure {
+	return LoginFailure{
+		Kind:        rowString(row["kind"]),
+		Subject:     rowString(row["subject"]),
+		Failures:    rowInt(row["failures"]),
+		LastFailure: rowInt(row["last_failure"]),
+		LockedUntil: rowInt(row["locked_until"]),
+	}
+}
+
+func rowAPIKey(row map[string]interface{}, userCol string) APIKey {
+	key := APIKey{
+		ID:      rowString(row["key_id"]),
+		User:    rowString(row[userCol]),
+		Name:    rowString(row["name"]),
+		Hash:    rowString(row["hash"]),
+		Created: rowInt(row["created"]),
+		Revoked: rowInt(row["revoked"]),
+	}
+	if json.Unmarshal([]byte(rowString(row["sensors"])), &key.Sensors) != nil || key.Sensors == nil {
+		key.Sensors = []string{}
+	}
+	return key
+}
+
+func encodeSensors(sensors []string) string {
+	if sensors == nil {
+		sensors = []string{}
+	}
+	b, _ := json.Marshal(sensors)
+	return string(b)
+}
+
+func rowUpload(row map[string]interface{}, userCol string) Upload {
+	return Upload{
+		Key:       rowString(row["idem_key"]),
+		TokenHash: rowString(row["token_hash"]),
+		User:      rowString(row[userCol]),
+		Expires:   rowInt(row["exp"]),
+		Started:   rowInt(row["started"]),
+		Status:    rowInt(row["status"]),
+		NewToken:  rowString(row["new_token"]),
+		Response:  rowString(row["response"]),
+	}
+}
+
+func rowLocation(row map[string]interface{}) Location {
+	return Location{
+		ID:        rowInt(row["l_id"]),
+		Owner:     rowString(row["owner"]),
+		Household: rowInt(row["h_id"]),
+		PlaceName: rowString(row["place_name"]),
+		Lat:       rowString(row["lat"]),
+		Lon:       rowString(row["lon"]),
+	}
+}
+
+func rowSensor(row map[string]interface{}, userCol string) Sensor {
+	return Sensor{
+		Owner:             rowString(row[userCol]),
+		Name:              rowString(row["sensor"]),
+		DisplayName:       rowString(row["display_name"]),
+		Room:              rowString(row["room"]),
+		Surface:           rowString(row["surface"]),
+		Side:              rowString(row["side"]),
+		Location:          rowInt(row["l_id"]),
+		Model:             rowString(row["model"]),
+		CalibrationOffset: rowFloat(row["calibration_offset"]),
+		Installed:         rowInt(row["installed"]),
+		Removed:           rowInt(row["removed"]),
+	}
+}
+
+func rowMeasurement(row map[string]interface{}, userCol string) Measurement {
+	return Measurement{
+		Owner:     rowString(row[userCol]),
+		Sensor:    rowString(row["sensor"]),
+		Kind:      rowString(row["kind"]),
+		Unit:      rowString(row["unit"]),
+		Timestamp: rowFloat(row["timestamp"]),
+		Value:     rowFloat(row["value"]),
+	}
+}
+
+func rowThermalFrame(row map[string]interface{}, userCol string) ThermalFrame {
+	return ThermalFrame{
+		ID:        rowInt(row["f_id"]),
+		Owner:     rowString(row[userCol]),
+		Sensor:    rowString(row["sensor"]),
+		Timestamp: rowFloat(row["timestamp"]),
+		Width:     rowInt(row["width"]),
+		Height:    rowInt(row["height"]),
+		Blob:      rowString(row["blob_key"]),
+		Min:       rowFloat(row["t_min"]),
+		Max:       rowFloat(row["t_max"]),
+		Mean:      rowFloat(row["t_mean"]),
+	}
+}
+
+func rowCalibration(row map[string]interface{}, userCol string) Calibration {
+	return Calibration{
+		ID:        rowInt(row["c_id"]),
+		Owner:     rowString(row[userCol]),
+		Sensor:    rowString(row["sensor"]),
+		Effective: rowFloat(row["effective"]),
+		Gain:      rowFloat(row["gain"]),
+		Offset:    rowFloat(row["cal_offset"]),
+	}
+}
+
+func rowHousehold(row map[string]interface{}) Household {
+	return Household{
+		ID:         rowInt(row["h_id"]),
+		Name:       rowString(row["name"]),
+		Created:    rowInt(row["created"]),
+		Permission: rowString(row["permission"]),
+	}
+}
+
+func rowWeather(row map[string]interface{}) Weather {
+	return Weather{
+		Timestamp:           rowFloat(row["timestamp"]),
+		SunUp:               rowBool(row["sun_up"]),
+		Temperature:         rowFloat(row["temperature"]),
+		ApparentTemperature: rowFloat(row["apparent_temperature"]),
+		CloudCover:          rowFloat(row["cloud_cover"]),
+		Humidity:            rowFloat(row["humidity"]),
+		Pressure:            rowFloat(row["pressure"]),
+		PrecipProbability:   rowFloat(row["precib_probability"]),
+	}
+}
+
+// The drivers hand back column values in different Go types depending on
+// the back-end and protocol, so rows are read through these helpers.
+
+func rowString(v interface{}) string {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case string:
+		return val
+	case nil:
+		return ""
+	}
+	return ""
+}
+
+func rowFloat(v interface{}) float64 {
+	switch val := v.(type) {
+	case float64:
+		return val
+	case float32:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case []byte:
+		f, _ := strconv.ParseFloat(string(val), 64)
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(val, 64)
+		return f
+	}
+	return 0
+}
+
+func rowInt(v interface{}) int64 {
+	switch val := v.(type) {
+	case int64:
+		return val
+	case float64:
+		return int64(val)
+	case []byte:
+		i, _ := strconv.ParseInt(string(val), 10, 64)
+		return i
+	case string:
+		i, _ := strconv.ParseInt(val, 10, 64)
+		return i
+	case bool:
+		if val {
+			return 1
+		}
+	}
+	return 0
+}
+
+func rowBool(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return rowInt(v) != 0
+}
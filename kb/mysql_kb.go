@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"log"
 	s "strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -30,23 +31,50 @@ type mysqlKB struct {
 func NewMysqlKB(user, password, dbname string, params map[string]string) *mysqlKB {
 	k := new(mysqlKB)
 	var err error
-	if params != nil {
-		args := make([]string, 0, len(params))
-		for k, v := range params {
-			args = append(args, s.Join([]string{k, v}, "="))
-		}
-		allArgs := s.Join(args, "&")
-		k.db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@/%s?%s", user, password, dbname, allArgs))
-	} else {
-		k.db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@/%s", user, password, dbname))
+	args := make([]string, 0, len(params)+1)
+	for k, v := range params {
+		args = append(args, s.Join([]string{k, v}, "="))
 	}
+	// Unless told otherwise, UPDATEs report the rows they matched rather
+	// than those they changed, so setting a value to what it already is
+	// still finds its row.
+	if _, ok := params["clientFoundRows"]; !ok {
+		args = append(args, "clientFoundRows=true")
+	}
+	allArgs := s.Join(args, "&")
+	k.db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@/%s?%s", user, password, dbname, allArgs))
 	if err != nil {
 		log.Fatal(err)
 	}
 	initMysqlDB(k.db)
+	migrate(k.db, mysql_migrations)
+	moveTable(k.db, mysql_temperaturesExist, mysql_moveTemperatures)
 	return k
 }
 
+// mysqlRowsPerInsert is how many rows a multi-row INSERT carries, well
+// below the server's limit of 65535 placeholders per statement.
+const mysqlRowsPerInsert = 500
+
+// mysqlInsertRows joins the argument lists of single rows into those of
+// multi-row INSERTs for doBatch.
+func mysqlInsertRows(rows [][]interface{}) [][]interface{} {
+	batchArgs := make([][]interface{}, 0, len(rows)/mysqlRowsPerInsert+1)
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > mysqlRowsPerInsert {
+			n = mysqlRowsPerInsert
+		}
+		args := make([]interface{}, 0, n*len(rows[0]))
+		for _, row := range rows[:n] {
+			args = append(args, row...)
+		}
+		batchArgs = append(batchArgs, args)
+		rows = rows[n:]
+	}
+	return batchArgs
+}
+
 func initMysqlDB(db *sql.DB) {
 	tx, err := db.Begin()
 	if err != nil {
@@ -66,9 +94,81 @@ func initMysqlDB(db *sql.DB) {
 		log.Fatal(err)
 	}
 
-	_, err = tx.Exec(mysql_createTemperature)
+	_, err = tx.Exec(mysql_createSession)
+	if err != nil {
+		log.Println("create sessions")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createRevoked)
+	if err != nil {
+		log.Println("create revoked")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createAPIKey)
+	if err != nil {
+		log.Println("create apikeys")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createLoginFailures)
+	if err != nil {
+		log.Println("create login_failures")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createUpload)
+	if err != nil {
+		log.Println("create uploads")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createHousehold)
+	if err != nil {
+		log.Println("create households")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createHouseholdMember)
+	if err != nil {
+		log.Println("create household_members")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createHouseholdSensor)
+	if err != nil {
+		log.Println("create household_sensors")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createAudit)
+	if err != nil {
+		log.Println("create audit")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createMeasurement)
+	if err != nil {
+		log.Println("create measurements")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createThermalFrame)
+	if err != nil {
+		log.Println("create thermal_frames")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createSensor)
+	if err != nil {
+		log.Println("create sensors")
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(mysql_createCalibration)
 	if err != nil {
-		log.Println("create temp")
+		log.Println("create calibrations")
 		log.Fatal(err)
 	}
 
@@ -77,6 +177,12 @@ func initMysqlDB(db *sql.DB) {
 		log.Println("create location")
 		log.Fatal(err)
 	}
+
+	_, err = tx.Exec(mysql_createWeather)
+	if err != nil {
+		log.Println("create weather")
+		log.Fatal(err)
+	}
 }
 
 func (k *mysqlKB) Stop() {
@@ -93,21 +199,25 @@ func (k *mysqlKB) GetHash(user string) ([]byte, bool) {
 	go doQuery(k.db, q)
 	rows := <-q.rows
 	if len(rows) == 1 {
-		return rows[0]["hashval"].([]byte), true
+		return []byte(rowString(rows[0]["hashval"])), true
 	} else {
 		return nil, false
 	}
 }
 
-func (k *mysqlKB) AddToken(user, token string, expiration int64) bool {
+func (k *mysqlKB) AddToken(user, token string, expiration int64, session string) bool {
 	q := &query{
 		queryString: mysql_addToken,
-		arguments:   []interface{}{user, token, expiration},
+		arguments:   []interface{}{user, token, expiration, session},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
 	go doInsert(k.db, q)
-	_, err := (<-q.result).RowsAffected()
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+	_, err := res.RowsAffected()
 	if err != nil {
 		return false
 	}
@@ -135,7 +245,118 @@ func (k *mysqlKB) AddUser(user string, hash string) bool {
 	return true
 }
 
-func (k *mysqlKB) GetUser(token string) (string, int64, bool) {
+func (k *mysqlKB) GetAccount(user string) (Account, bool) {
+	q := &query{
+		queryString: mysql_getAccount,
+		arguments:   []interface{}{user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Account{}, false
+	}
+	return rowAccount(rows[0], "uname"), true
+}
+
+func (k *mysqlKB) ListAccounts() []Account {
+	q := &query{
+		queryString: mysql_listAccounts,
+		arguments:   nil,
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	accounts := make([]Account, 0, len(rows))
+	for _, row := range rows {
+		accounts = append(accounts, rowAccount(row, "uname"))
+	}
+	return accounts
+}
+
+func (k *mysqlKB) SetHash(user, hash string) bool {
+	return k.updateUser(mysql_setHash, hash, user)
+}
+
+func (k *mysqlKB) SetRole(user, role string) bool {
+	return k.updateUser(mysql_setRole, role, user)
+}
+
+func (k *mysqlKB) SetDisabled(user string, disabled bool) bool {
+	return k.updateUser(mysql_setDisabled, disabled, user)
+}
+
+// DeleteUser removes a user together with their tokens, device keys,
+// temperatures, locations and the weather of those locations, and the
+// households no one else is in, all in one transaction. Nothing is removed
+// if there is no such user.
+func (k *mysqlKB) DeleteUser(user string) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	for _, b := range []struct {
+		queryString string
+		arguments   []interface{}
+	}{
+		{mysql_deleteUserTokens, []interface{}{user}},
+		{mysql_deleteUserSessions, []interface{}{user}},
+		{mysql_deleteUserAPIKeys, []interface{}{user}},
+		{mysql_deleteUserUploads, []interface{}{user}},
+		{mysql_deleteUserLoginFailures, []interface{}{user}},
+		{mysql_deleteUserMeasurements, []interface{}{user}},
+		{mysql_deleteUserWeather, []interface{}{user}},
+		{mysql_detachSoleHouseholdLocations, []interface{}{user, user}},
+		{mysql_deleteSoleHouseholdSensors, []interface{}{user, user}},
+		{mysql_deleteSoleHouseholds, []interface{}{user, user}},
+		{mysql_deleteUserHouseholdSensors, []interface{}{user}},
+		{mysql_deleteUserMemberships, []interface{}{user}},
+		{mysql_deleteUserCalibrations, []interface{}{user}},
+		{mysql_deleteUserThermalFrames, []interface{}{user}},
+		{mysql_deleteUserSensors, []interface{}{user}},
+		{mysql_deleteUserLocations, []interface{}{user}},
+	} {
+		q.batch = append(q.batch, &query{queryString: b.queryString, arguments: b.arguments})
+	}
+	q.batch = append(q.batch, &query{queryString: mysql_deleteUser, arguments: []interface{}{user}, mustAffect: true})
+	go doTx(k.db, q)
+
+	_, ok := <-q.result
+	return ok
+}
+
+// updateUser runs a statement on the auth table and reports whether it
+// matched a row.
+func (k *mysqlKB) updateUser(queryString string, arguments ...interface{}) bool {
+	q := &query{
+		queryString: queryString,
+		arguments:   arguments,
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// GetUser returns the owner, expiration and session of a rotating token.
+func (k *mysqlKB) GetUser(token string) (string, int64, string, bool) {
 	q := &query{
 		queryString: mysql_getUser,
 		arguments:   []interface{}{token},
@@ -145,10 +366,9 @@ func (k *mysqlKB) GetUser(token string) (string, int64, bool) {
 	go doQuery(k.db, q)
 	rows := <-q.rows
 	if len(rows) == 1 {
-		//              log.Printf("%T.%T\n", rows[0]["user"], rows[0]["exp"])
-		return string(rows[0]["uname"].([]byte)), rows[0]["exp"].(int64), true
+		return rowString(rows[0]["uname"]), rowInt(rows[0]["exp"]), rowString(rows[0]["session"]), true
 	} else {
-		return "", 0, false
+		return "", 0, "", false
 	}
 }
 
@@ -174,10 +394,36 @@ func (k *mysqlKB) ExpireToken(token string) bool {
 	return true
 }
 
+// PurgeTokens deletes rotating tokens, revocations and remembered uploads
+// that expired before expiration.
 func (k *mysqlKB) PurgeTokens(expiration int64) bool {
+	for _, queryString := range []string{mysql_purgeTokens, mysql_purgeSessions, mysql_purgeRevoked, mysql_purgeUploads} {
+		q := &query{
+			queryString: queryString,
+			arguments:   []interface{}{expiration},
+			rows:        nil,
+			result:      make(chan sql.Result),
+		}
+		go doInsert(k.db, q)
+
+		res, ok := <-q.result
+		if !ok {
+			return false
+		}
+
+		_, err := res.RowsAffected()
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (k *mysqlKB) AddRevoked(tokenID string, expiration int64) bool {
 	q := &query{
-		queryString: mysql_purgeTokens,
-		arguments:   []interface{}{expiration},
+		queryString: mysql_addRevoked,
+		arguments:   []interface{}{tokenID, expiration},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
@@ -192,14 +438,35 @@ func (k *mysqlKB) PurgeTokens(expiration int64) bool {
 	if err != nil {
 		return false
 	}
-
 	return true
 }
 
-func (k *mysqlKB) AddTemperature(user, sensor string, timestamp, value float64) bool {
+// GetRevoked returns the revoked signed token ids that have not expired by
+// now, mapped to their expiration.
+func (k *mysqlKB) GetRevoked(now int64) (map[string]int64, bool) {
+	q := &query{
+		queryString: mysql_getRevoked,
+		arguments:   []interface{}{now},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil, false
+	}
+	out := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		out[rowString(row["token_id"])] = rowInt(row["exp"])
+	}
+	return out, true
+}
+
+func (k *mysqlKB) AddSession(session Session) bool {
 	q := &query{
-		queryString: mysql_addTemperature,
-		arguments:   []interface{}{user, sensor, timestamp, value},
+		queryString: mysql_addSession,
+		arguments:   []interface{}{session.ID, session.User, session.Created, session.Expires, session.LastUsed, session.ClientIP},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
@@ -207,7 +474,6 @@ func (k *mysqlKB) AddTemperature(user, sensor string, timestamp, value float64)
 
 	res, ok := <-q.result
 	if !ok {
-		log.Printf("%v\n", q)
 		return false
 	}
 
@@ -215,14 +481,14 @@ func (k *mysqlKB) AddTemperature(user, sensor string, timestamp, value float64)
 	if err != nil {
 		return false
 	}
-
 	return true
 }
 
-func (k *mysqlKB) AddWeather(location, timestamp int64, sunUp bool, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability float64) bool {
+// TouchSession records a use of a session that has not been revoked.
+func (k *mysqlKB) TouchSession(id string, expiration, lastUsed int64, clientIP string) bool {
 	q := &query{
-		queryString: mysql_addWeather,
-		arguments:   []interface{}{location, timestamp, sunUp, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability},
+		queryString: mysql_touchSession,
+		arguments:   []interface{}{expiration, lastUsed, clientIP, id},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
@@ -230,61 +496,151 @@ func (k *mysqlKB) AddWeather(location, timestamp int64, sunUp bool, temperature,
 
 	res, ok := <-q.result
 	if !ok {
-		log.Printf("%v\n", q)
 		return false
 	}
 
-	_, err := res.RowsAffected()
-	if err != nil {
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
 		return false
 	}
-
 	return true
 }
 
-func (k *mysqlKB) GetTemperatures(user, sensor string, start, end float64) map[float64]float64 {
+func (k *mysqlKB) GetSession(id string) (Session, bool) {
+	q := &query{
+		queryString: mysql_getSession,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Session{}, false
+	}
+	return rowSession(rows[0], "uname"), true
+}
+
+// ListSessions returns the user's sessions that have not expired by now.
+func (k *mysqlKB) ListSessions(user string, now int64) []Session {
 	q := &query{
-		queryString: mysql_getTemperatures,
-		arguments:   []interface{}{user, sensor, start, end},
+		queryString: mysql_listSessions,
+		arguments:   []interface{}{user, now},
 		rows:        make(chan []map[string]interface{}),
 		result:      nil,
 	}
 	go doQuery(k.db, q)
 
 	rows, ok := <-q.rows
-	out = make(map[float64]float64)
 	if !ok {
-		return out
+		return nil
 	}
+	sessions := make([]Session, 0, len(rows))
 	for _, row := range rows {
-		out[row["timestamp"].(float64)] = row["value"].(float64)
+		sessions = append(sessions, rowSession(row, "uname"))
 	}
-	return out
+	return sessions
+}
+
+// RevokeSession expires one of the user's sessions and its tokens, or all
+// of them when id is empty.
+func (k *mysqlKB) RevokeSession(user, id string) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	if id == "" {
+		q.batch = []*query{
+			{queryString: mysql_expireUserTokens, arguments: []interface{}{user}},
+			{queryString: mysql_expireUserSessions, arguments: []interface{}{user}},
+		}
+	} else {
+		q.batch = []*query{
+			{queryString: mysql_expireSessionTokens, arguments: []interface{}{user, id}},
+			{queryString: mysql_expireSession, arguments: []interface{}{user, id}},
+		}
+	}
+	go doTx(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || (id != "" && n == 0) {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) AddAudit(event AuditEvent) bool {
+	q := &query{
+		queryString: mysql_addAudit,
+		arguments:   []interface{}{event.Timestamp, event.User, event.Action, event.Subject, event.ClientIP, event.Detail},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
 }
 
-func (k *mysqlKB) GetTemperatureSensors(user string, start, end float64) []string {
+// GetAudit returns the events in a time range, oldest first. With a user,
+// only the events they caused or that were done to them.
+func (k *mysqlKB) GetAudit(user string, start, end int64) []AuditEvent {
 	q := &query{
-		queryString: mysql_getTemperatureSensors,
-		arguments:   []interface{}{user, start, end},
+		queryString: mysql_getAudit,
+		arguments:   []interface{}{start, end},
 		rows:        make(chan []map[string]interface{}),
 		result:      nil,
 	}
+	if user != "" {
+		q.queryString = mysql_getUserAudit
+		q.arguments = []interface{}{user, user, start, end}
+	}
 	go doQuery(k.db, q)
 
 	rows, ok := <-q.rows
 	if !ok {
 		return nil
 	}
-	sens := make([]string, 0, len(rows))
+	events := make([]AuditEvent, 0, len(rows))
 	for _, row := range rows {
-		sens = append(sens, string(row["sensor"].([]byte)))
+		events = append(events, rowAuditEvent(row, "uname"))
 	}
-	return sens
+	return events
 }
 
-func (k *mysqlKB) GetCoordinates() ([][]string, []int64, bool) {
+func (k *mysqlKB) GetLoginFailure(kind, subject string) (LoginFailure, bool) {
 	q := &query{
-		queryString: mysql_getCoordinates,
+		queryString: mysql_getLoginFailure,
+		arguments:   []interface{}{kind, subject},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return LoginFailure{Kind: kind, Subject: subject}, false
+	}
+	return rowLoginFailure(rows[0]), true
+}
+
+func (k *mysqlKB) ListLoginFailures() []LoginFailure {
+	q := &query{
+		queryString: mysql_listLoginFailures,
 		arguments:   nil,
 		rows:        make(chan []map[string]interface{}),
 		result:      nil,
@@ -293,19 +649,1129 @@ func (k *mysqlKB) GetCoordinates() ([][]string, []int64, bool) {
 
 	rows, ok := <-q.rows
 	if !ok {
-		return nil, nil, false
-	}
-	if len(rows) == 0 {
-		return nil, nil, false
+		return nil
 	}
-	coords := make([][]string, 0, len(rows))
-	l_ids := make([]int64, 0, len(rows))
+	out := make([]LoginFailure, 0, len(rows))
 	for _, row := range rows {
-		pair := make([]string, 2)
-		pair[0] = string(row["lat"].([]byte))
-		pair[1] = string(row["long"].([]byte))
-		coords = append(coords, pair)
-		l_ids = append(l_ids, row["l_id"].(int64))
+		out = append(out, rowLoginFailure(row))
 	}
-	return coords, l_ids, true
+	return out
+}
+
+func (k *mysqlKB) SetLoginFailure(f LoginFailure) bool {
+	q := &query{
+		queryString: mysql_setLoginFailure,
+		arguments:   []interface{}{f.Kind, f.Subject, f.Failures, f.LastFailure, f.LockedUntil},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// ClearLoginFailure forgets the failures of a subject; it reports false when
+// there were none.
+func (k *mysqlKB) ClearLoginFailure(kind, subject string) bool {
+	q := &query{
+		queryString: mysql_clearLoginFailure,
+		arguments:   []interface{}{kind, subject},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) AddAPIKey(user string, key APIKey) bool {
+	q := &query{
+		queryString: mysql_addAPIKey,
+		arguments:   []interface{}{user, key.ID, key.Name, key.Hash, encodeSensors(key.Sensors), key.Created},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) GetAPIKey(id string) (APIKey, bool) {
+	q := &query{
+		queryString: mysql_getAPIKey,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return APIKey{}, false
+	}
+	return rowAPIKey(rows[0], "uname"), true
+}
+
+func (k *mysqlKB) ListAPIKeys(user string) []APIKey {
+	q := &query{
+		queryString: mysql_listAPIKeys,
+		arguments:   []interface{}{user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, rowAPIKey(row, "uname"))
+	}
+	return keys
+}
+
+// RevokeAPIKey marks one of the user's keys revoked as of when; it reports
+// false when there was no such active key.
+func (k *mysqlKB) RevokeAPIKey(user, id string, when int64) bool {
+	q := &query{
+		queryString: mysql_revokeAPIKey,
+		arguments:   []interface{}{when, user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// AddUpload claims an idempotency key for the upload about to be handled.
+// It fails if the key is already taken for the same token.
+func (k *mysqlKB) AddUpload(upload Upload) bool {
+	q := &query{
+		queryString: mysql_addUpload,
+		arguments:   []interface{}{upload.Key, upload.TokenHash, upload.User, upload.Expires, upload.Started},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) GetUpload(tokenHash, key string) (Upload, bool) {
+	q := &query{
+		queryString: mysql_getUpload,
+		arguments:   []interface{}{tokenHash, key},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Upload{}, false
+	}
+	return rowUpload(rows[0], "uname"), true
+}
+
+// TakeOverUpload claims an idempotency key again for upload, if the attempt
+// that claimed it at started has not finished. It fails if another retry
+// took the key over first.
+func (k *mysqlKB) TakeOverUpload(upload Upload, started int64) bool {
+	q := &query{
+		queryString: mysql_takeOverUpload,
+		arguments:   []interface{}{upload.User, upload.Expires, upload.Started, upload.TokenHash, upload.Key, started},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// FinishUpload stores the answer to an upload, unless its key has since
+// been taken over by a retry.
+func (k *mysqlKB) FinishUpload(upload Upload) bool {
+	q := &query{
+		queryString: mysql_finishUpload,
+		arguments:   []interface{}{upload.Status, upload.NewToken, upload.Response, upload.TokenHash, upload.Key, upload.Started},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// DeleteUpload frees an idempotency key, so the next upload with it is
+// handled afresh.
+func (k *mysqlKB) DeleteUpload(tokenHash, key string) bool {
+	q := &query{
+		queryString: mysql_deleteUpload,
+		arguments:   []interface{}{tokenHash, key},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) AddTemperature(user, sensor string, timestamp, value float64) bool {
+	q := &query{
+		queryString: mysql_addMeasurement,
+		arguments:   []interface{}{user, sensor, KindTemperature, kindUnits[KindTemperature], timestamp, value},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		log.Printf("%v\n", q)
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (k *mysqlKB) AddMeasurement(m Measurement) bool {
+	q := &query{
+		queryString: mysql_addMeasurement,
+		arguments:   []interface{}{m.Owner, m.Sensor, m.Kind, m.Unit, m.Timestamp, m.Value},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// AddMeasurements stores the measurements in one transaction and returns
+// how many of them were new. Those already stored are left as they are.
+func (k *mysqlKB) AddMeasurements(ms []Measurement) (int64, bool) {
+	q := &query{
+		queryString: mysql_addMeasurement,
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	rows := make([][]interface{}, 0, len(ms))
+	for _, m := range ms {
+		rows = append(rows, []interface{}{m.Owner, m.Sensor, m.Kind, m.Unit, m.Timestamp, m.Value})
+	}
+	q.batchArgs = mysqlInsertRows(rows)
+	go doBatch(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// GetMeasurements returns the measurements of the user's sensors and of
+// those shared with their households in the range. An empty sensor or kind
+// matches all of them.
+func (k *mysqlKB) GetMeasurements(user, sensor, kind string, start, end float64) []Measurement {
+	q := &query{
+		queryString: mysql_getMeasurements,
+		arguments:   []interface{}{sensor, sensor, kind, kind, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	ms := make([]Measurement, 0, len(rows))
+	for _, row := range rows {
+		ms = append(ms, rowMeasurement(row, "uname"))
+	}
+	return ms
+}
+
+// AddTemperatures stores the readings in one transaction and returns how
+// many of them were new.
+func (k *mysqlKB) AddTemperatures(user, sensor string, readings []Reading) (int64, bool) {
+	q := &query{
+		queryString: mysql_addMeasurement,
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	rows := make([][]interface{}, 0, len(readings))
+	for _, r := range readings {
+		rows = append(rows, []interface{}{user, sensor, KindTemperature, kindUnits[KindTemperature], r.Timestamp, r.Value})
+	}
+	q.batchArgs = mysqlInsertRows(rows)
+	go doBatch(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func (k *mysqlKB) AddWeather(location, timestamp int64, sunUp bool, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability float64) bool {
+	q := &query{
+		queryString: mysql_addWeather,
+		arguments:   []interface{}{location, timestamp, sunUp, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		log.Printf("%v\n", q)
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (k *mysqlKB) GetWeather(location int64, start, end float64) []Weather {
+	q := &query{
+		queryString: mysql_getWeather,
+		arguments:   []interface{}{location, start, end},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	out := make([]Weather, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, rowWeather(row))
+	}
+	return out
+}
+
+// GetSensorTemperatures returns the readings of a sensor in the range, if
+// the user owns it or its owner shares it with one of their households.
+func (k *mysqlKB) GetSensorTemperatures(user string, ref SensorRef, start, end float64) map[float64]float64 {
+	q := &query{
+		queryString: mysql_getSensorTemperatures,
+		arguments:   []interface{}{ref.Owner, ref.Sensor, KindTemperature, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	out := make(map[float64]float64)
+	if !ok {
+		return out
+	}
+	for _, row := range rows {
+		out[rowFloat(row["timestamp"])] = rowFloat(row["value"])
+	}
+	return out
+}
+
+// GetTemperatureRefs lists the user's own sensors and those shared with
+// their households that have readings in the range, ordered by name. Two
+// people's sensors may share a name, so each comes with its owner.
+func (k *mysqlKB) GetTemperatureRefs(user string, start, end float64) []SensorRef {
+	q := &query{
+		queryString: mysql_getTemperatureRefs,
+		arguments:   []interface{}{KindTemperature, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	refs := make([]SensorRef, 0, len(rows))
+	for _, row := range rows {
+		refs = append(refs, SensorRef{Owner: rowString(row["uname"]), Sensor: rowString(row["sensor"])})
+	}
+	return refs
+}
+
+func (k *mysqlKB) GetAlignedSeries(user string, location int64, sensors []string, start, end, interval float64, fill Fill) (*AlignedSeries, bool) {
+	return alignSeries(k, user, location, sensors, start, end, interval, fill)
+}
+
+func (k *mysqlKB) AddThermalFrame(frame ThermalFrame) (int64, bool) {
+	q := &query{
+		queryString: mysql_addThermalFrame,
+		arguments:   []interface{}{frame.Owner, frame.Sensor, frame.Timestamp, frame.Width, frame.Height, frame.Blob, frame.Min, frame.Max, frame.Mean},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// GetThermalFrame returns a frame of the user's or of a sensor shared with
+// their households.
+func (k *mysqlKB) GetThermalFrame(user string, id int64) (ThermalFrame, bool) {
+	q := &query{
+		queryString: mysql_getThermalFrame,
+		arguments:   []interface{}{id, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return ThermalFrame{}, false
+	}
+	return rowThermalFrame(rows[0], "uname"), true
+}
+
+// GetThermalFrames lists the frames taken in the range by sensor, or by all
+// of the user's sensors and those shared with them when sensor is empty.
+func (k *mysqlKB) GetThermalFrames(user, sensor string, start, end float64) []ThermalFrame {
+	q := &query{
+		queryString: mysql_getThermalFrames,
+		arguments:   []interface{}{sensor, sensor, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	frames := make([]ThermalFrame, 0, len(rows))
+	for _, row := range rows {
+		frames = append(frames, rowThermalFrame(row, "uname"))
+	}
+	return frames
+}
+
+func (k *mysqlKB) DeleteThermalFrame(user string, id int64) bool {
+	q := &query{
+		queryString: mysql_deleteThermalFrame,
+		arguments:   []interface{}{user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) AddSensor(sensor Sensor) bool {
+	q := &query{
+		queryString: mysql_addSensor,
+		arguments:   []interface{}{sensor.Owner, sensor.Name, sensor.DisplayName, sensor.Room, sensor.Surface, sensor.Side, sensor.Location, sensor.Model, sensor.CalibrationOffset, sensor.Installed, sensor.Removed},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// GetSensor returns the metadata of one of the user's own sensors.
+func (k *mysqlKB) GetSensor(user, sensor string) (Sensor, bool) {
+	q := &query{
+		queryString: mysql_getSensor,
+		arguments:   []interface{}{user, sensor},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Sensor{}, false
+	}
+	return rowSensor(rows[0], "uname"), true
+}
+
+// ListSensors returns the metadata of the user's sensors and of those shared
+// with their households.
+func (k *mysqlKB) ListSensors(user string) []Sensor {
+	q := &query{
+		queryString: mysql_listSensors,
+		arguments:   []interface{}{user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	sensors := make([]Sensor, 0, len(rows))
+	for _, row := range rows {
+		sensors = append(sensors, rowSensor(row, "uname"))
+	}
+	return sensors
+}
+
+func (k *mysqlKB) UpdateSensor(sensor Sensor) bool {
+	q := &query{
+		queryString: mysql_updateSensor,
+		arguments:   []interface{}{sensor.DisplayName, sensor.Room, sensor.Surface, sensor.Side, sensor.Location, sensor.Model, sensor.CalibrationOffset, sensor.Installed, sensor.Removed, sensor.Owner, sensor.Name},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// DeleteSensor removes a sensor's metadata. Its readings are kept.
+func (k *mysqlKB) DeleteSensor(user, sensor string) bool {
+	q := &query{
+		queryString: mysql_deleteSensor,
+		arguments:   []interface{}{user, sensor},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) AddCalibration(cal Calibration) (int64, bool) {
+	q := &query{
+		queryString: mysql_addCalibration,
+		arguments:   []interface{}{cal.Owner, cal.Sensor, cal.Effective, cal.Gain, cal.Offset},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// ListCalibrations returns the user's calibrations for sensor, or for all
+// their sensors when sensor is empty, oldest first.
+func (k *mysqlKB) ListCalibrations(user, sensor string) []Calibration {
+	q := &query{
+		queryString: mysql_listCalibrations,
+		arguments:   []interface{}{user, sensor, sensor},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	cals := make([]Calibration, 0, len(rows))
+	for _, row := range rows {
+		cals = append(cals, rowCalibration(row, "uname"))
+	}
+	return cals
+}
+
+func (k *mysqlKB) DeleteCalibration(user string, id int64) bool {
+	q := &query{
+		queryString: mysql_deleteCalibration,
+		arguments:   []interface{}{user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) GetCoordinates() ([][]string, []int64, bool) {
+	q := &query{
+		queryString: mysql_getCoordinates,
+		arguments:   nil,
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil, nil, false
+	}
+	if len(rows) == 0 {
+		return nil, nil, false
+	}
+	coords := make([][]string, 0, len(rows))
+	l_ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		pair := make([]string, 2)
+		pair[0] = rowString(row["lat"])
+		pair[1] = rowString(row["lon"])
+		coords = append(coords, pair)
+		l_ids = append(l_ids, rowInt(row["l_id"]))
+	}
+	return coords, l_ids, true
+}
+
+func (k *mysqlKB) AddLocation(user, placeName, lat, lon string) (int64, bool) {
+	q := &query{
+		queryString: mysql_addLocation,
+		arguments:   []interface{}{user, placeName, lat, lon},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func (k *mysqlKB) GetLocation(user string, id int64) (Location, bool) {
+	q := &query{
+		queryString: mysql_getLocation,
+		arguments:   []interface{}{id, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Location{}, false
+	}
+	return rowLocation(rows[0]), true
+}
+
+func (k *mysqlKB) ListLocations(user string) []Location {
+	q := &query{
+		queryString: mysql_listLocations,
+		arguments:   []interface{}{user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	locs := make([]Location, 0, len(rows))
+	for _, row := range rows {
+		locs = append(locs, rowLocation(row))
+	}
+	return locs
+}
+
+func (k *mysqlKB) UpdateLocation(user string, id int64, placeName, lat, lon string) bool {
+	q := &query{
+		queryString: mysql_updateLocation,
+		arguments:   []interface{}{placeName, lat, lon, user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// DeleteLocation removes the location and the weather collected for it.
+func (k *mysqlKB) DeleteLocation(user string, id int64) bool {
+	for _, queryString := range []string{mysql_deleteLocationWeather, mysql_detachLocationSensors, mysql_deleteLocation} {
+		q := &query{
+			queryString: queryString,
+			arguments:   []interface{}{user, id},
+			rows:        nil,
+			result:      make(chan sql.Result),
+		}
+		go doInsert(k.db, q)
+
+		res, ok := <-q.result
+		if !ok {
+			return false
+		}
+
+		_, err := res.RowsAffected()
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *mysqlKB) GetUserTemperatures(user string) map[string]map[float64]float64 {
+	q := &query{
+		queryString: mysql_getUserTemperatures,
+		arguments:   []interface{}{user, KindTemperature},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	out := make(map[string]map[float64]float64)
+	if !ok {
+		return out
+	}
+	for _, row := range rows {
+		sensor := rowString(row["sensor"])
+		if out[sensor] == nil {
+			out[sensor] = make(map[float64]float64)
+		}
+		out[sensor][rowFloat(row["timestamp"])] = rowFloat(row["value"])
+	}
+	return out
+}
+
+// AddHousehold creates a household with the user as its first admin.
+func (k *mysqlKB) AddHousehold(user, name string) (int64, bool) {
+	q := &query{
+		queryString: mysql_addHousehold,
+		arguments:   []interface{}{name, time.Now().Unix()},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, k.SetMember(id, user, HouseholdAdmin)
+}
+
+func (k *mysqlKB) GetHousehold(id int64) (Household, bool) {
+	q := &query{
+		queryString: mysql_getHousehold,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Household{}, false
+	}
+	return rowHousehold(rows[0]), true
+}
+
+func (k *mysqlKB) ListHouseholds(user string) []Household {
+	q := &query{
+		queryString: mysql_listHouseholds,
+		arguments:   []interface{}{user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	households := make([]Household, 0, len(rows))
+	for _, row := range rows {
+		households = append(households, rowHousehold(row))
+	}
+	return households
+}
+
+// DeleteHousehold removes a household and its memberships. Its locations
+// and sensors go back to being private to the users who own them.
+func (k *mysqlKB) DeleteHousehold(id int64) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	for _, b := range []struct {
+		queryString string
+		arguments   []interface{}
+	}{
+		{mysql_detachHouseholdLocations, []interface{}{id}},
+		{mysql_deleteHouseholdSensors, []interface{}{id}},
+		{mysql_deleteHouseholdMembers, []interface{}{id}},
+		{mysql_deleteHousehold, []interface{}{id}},
+	} {
+		q.batch = append(q.batch, &query{queryString: b.queryString, arguments: b.arguments})
+	}
+	go doTx(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) GetMembership(id int64, user string) (string, bool) {
+	q := &query{
+		queryString: mysql_getMembership,
+		arguments:   []interface{}{id, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return "", false
+	}
+	return rowString(rows[0]["permission"]), true
+}
+
+func (k *mysqlKB) SetMember(id int64, user, permission string) bool {
+	q := &query{
+		queryString: mysql_setMember,
+		arguments:   []interface{}{id, user, permission},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// RemoveMember takes a user out of a household along with the sensors and
+// locations they shared with it.
+func (k *mysqlKB) RemoveMember(id int64, user string) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	for _, b := range []struct {
+		queryString string
+		arguments   []interface{}
+	}{
+		{mysql_removeMemberSensors, []interface{}{id, user}},
+		{mysql_detachMemberLocations, []interface{}{id, user}},
+		{mysql_removeMember, []interface{}{id, user}},
+	} {
+		q.batch = append(q.batch, &query{queryString: b.queryString, arguments: b.arguments})
+	}
+	go doTx(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) ListMembers(id int64) []HouseholdMember {
+	q := &query{
+		queryString: mysql_listMembers,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	members := make([]HouseholdMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, HouseholdMember{
+			User:       rowString(row["uname"]),
+			Permission: rowString(row["permission"]),
+		})
+	}
+	return members
+}
+
+func (k *mysqlKB) AddHouseholdSensor(id int64, user, sensor string) bool {
+	q := &query{
+		queryString: mysql_addHouseholdSensor,
+		arguments:   []interface{}{id, user, sensor},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) RemoveHouseholdSensor(id int64, user, sensor string) bool {
+	q := &query{
+		queryString: mysql_removeHouseholdSensor,
+		arguments:   []interface{}{id, user, sensor},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *mysqlKB) ListHouseholdSensors(id int64) []HouseholdSensor {
+	q := &query{
+		queryString: mysql_listHouseholdSensors,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	go doQuery(k.db, q)
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	sensors := make([]HouseholdSensor, 0, len(rows))
+	for _, row := range rows {
+		sensors = append(sensors, HouseholdSensor{
+			User:   rowString(row["uname"]),
+			Sensor: rowString(row["sensor"]),
+		})
+	}
+	return sensors
+}
+
+// SetLocationHousehold shares one of the user's locations with a household,
+// or makes it private again when household is 0.
+func (k *mysqlKB) SetLocationHousehold(user string, location, household int64) bool {
+	q := &query{
+		queryString: mysql_setLocationHousehold,
+		arguments:   []interface{}{household, user, location},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	go doInsert(k.db, q)
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
 }
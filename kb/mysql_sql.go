@@ -28,12 +28,103 @@ const mysql_createToken = `CREATE TABLE IF NOT EXISTS tokens (
 	exp   BIGINT NOT NULL
 )`
 
-const mysql_createTemperature = `CREATE TABLE IF NOT EXISTS temperatures(
+const mysql_createSession = `CREATE TABLE IF NOT EXISTS sessions (
+	s_id        CHAR(32) NOT NULL,
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	created     BIGINT NOT NULL,
+	exp         BIGINT NOT NULL,
+	last_used   BIGINT NOT NULL,
+	client_ip   VARCHAR(64) NOT NULL,
+	PRIMARY KEY (s_id)
+)`
+
+const mysql_createRevoked = `CREATE TABLE IF NOT EXISTS revoked (
+	token_id CHAR(32) NOT NULL,
+	exp      BIGINT NOT NULL,
+	PRIMARY KEY (token_id)
+)`
+
+const mysql_createAPIKey = `CREATE TABLE IF NOT EXISTS apikeys (
+	key_id      CHAR(16) NOT NULL,
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	name        VARCHAR(255) NOT NULL,
+	hash        CHAR(64) NOT NULL,
+	sensors     TEXT NOT NULL,
+	created     BIGINT NOT NULL,
+	revoked     BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (key_id)
+)`
+
+const mysql_createUpload = `CREATE TABLE IF NOT EXISTS uploads (
+	idem_key    VARCHAR(255) NOT NULL,
+	token_hash  CHAR(64) NOT NULL,
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	exp         BIGINT NOT NULL,
+	started     BIGINT NOT NULL DEFAULT 0,
+	status      BIGINT NOT NULL DEFAULT 0,
+	new_token   VARCHAR(255) NOT NULL DEFAULT '',
+	response    MEDIUMTEXT NOT NULL,
+	PRIMARY KEY (token_hash, idem_key)
+)`
+
+const mysql_createLoginFailures = `CREATE TABLE IF NOT EXISTS login_failures (
+	kind         VARCHAR(8) NOT NULL,
+	subject      VARCHAR(255) NOT NULL,
+	failures     BIGINT NOT NULL,
+	last_failure BIGINT NOT NULL,
+	locked_until BIGINT NOT NULL,
+	PRIMARY KEY (kind, subject)
+)`
+
+const mysql_createMeasurement = `CREATE TABLE IF NOT EXISTS measurements(
 	uname       VARCHAR(255) REFERENCES auth (uname),
 	sensor      VARCHAR(255) NOT NULL,
+	kind        VARCHAR(32) NOT NULL,
+	unit        VARCHAR(16) NOT NULL,
 	timestamp   DOUBLE NOT NULL,
 	value       DOUBLE NOT NULL,
-	PRIMARY KEY (uname,sensor,timestamp)
+	PRIMARY KEY (uname,sensor,kind,timestamp)
+)`
+
+const mysql_createThermalFrame = `CREATE TABLE IF NOT EXISTS thermal_frames(
+	f_id        INTEGER AUTO_INCREMENT,
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	sensor      VARCHAR(255) NOT NULL,
+	timestamp   DOUBLE NOT NULL,
+	width       INTEGER NOT NULL,
+	height      INTEGER NOT NULL,
+	blob_key    VARCHAR(255) NOT NULL,
+	t_min       DOUBLE NOT NULL,
+	t_max       DOUBLE NOT NULL,
+	t_mean      DOUBLE NOT NULL,
+	PRIMARY KEY (f_id),
+	UNIQUE KEY  (uname,sensor,timestamp)
+)`
+
+const mysql_createSensor = `CREATE TABLE IF NOT EXISTS sensors(
+	uname              VARCHAR(255) REFERENCES auth (uname),
+	sensor             VARCHAR(255) NOT NULL,
+	display_name       VARCHAR(255) NOT NULL,
+	room               VARCHAR(255) NOT NULL,
+	surface            VARCHAR(16) NOT NULL,
+	side               VARCHAR(16) NOT NULL,
+	l_id               INTEGER NOT NULL,
+	model              VARCHAR(255) NOT NULL,
+	calibration_offset DOUBLE NOT NULL,
+	installed          BIGINT NOT NULL,
+	removed            BIGINT NOT NULL,
+	PRIMARY KEY        (uname, sensor)
+)`
+
+const mysql_createCalibration = `CREATE TABLE IF NOT EXISTS calibrations(
+	c_id        INTEGER AUTO_INCREMENT,
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	sensor      VARCHAR(255) NOT NULL,
+	effective   DOUBLE NOT NULL,
+	gain        DOUBLE NOT NULL,
+	cal_offset  DOUBLE NOT NULL,
+	PRIMARY KEY (c_id),
+	UNIQUE KEY  (uname,sensor,effective)
 )`
 
 const mysql_createLocation = `CREATE TABLE IF NOT EXISTS location(
@@ -59,31 +150,281 @@ const mysql_createWeather = `CREATE TABLE IF NOT EXISTS weather(
 	PRIMARY KEY          (l_id, timestamp)
 )`
 
+const mysql_createHousehold = `CREATE TABLE IF NOT EXISTS households(
+	h_id        INTEGER AUTO_INCREMENT,
+	name        VARCHAR(255) NOT NULL,
+	created     BIGINT NOT NULL,
+	PRIMARY KEY (h_id)
+)`
+
+const mysql_createHouseholdMember = `CREATE TABLE IF NOT EXISTS household_members(
+	h_id        INTEGER REFERENCES households (h_id),
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	permission  VARCHAR(16) NOT NULL,
+	PRIMARY KEY (h_id, uname)
+)`
+
+const mysql_createHouseholdSensor = `CREATE TABLE IF NOT EXISTS household_sensors(
+	h_id        INTEGER REFERENCES households (h_id),
+	uname       VARCHAR(255) REFERENCES auth (uname),
+	sensor      VARCHAR(255) NOT NULL,
+	PRIMARY KEY (h_id, uname, sensor)
+)`
+
+const mysql_createAudit = `CREATE TABLE IF NOT EXISTS audit(
+	a_id        BIGINT AUTO_INCREMENT,
+	timestamp   BIGINT NOT NULL,
+	uname       VARCHAR(255) NOT NULL,
+	action      VARCHAR(32) NOT NULL,
+	subject     VARCHAR(255) NOT NULL,
+	client_ip   VARCHAR(64) NOT NULL,
+	detail      TEXT NOT NULL,
+	PRIMARY KEY (a_id),
+	KEY         (timestamp)
+)`
+
+// Columns added to existing tables, see migrate
+
+var mysql_migrations = []string{
+	`ALTER TABLE auth ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user'`,
+	`ALTER TABLE auth ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE location ADD COLUMN h_id INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tokens ADD COLUMN session CHAR(32) NOT NULL DEFAULT ''`,
+}
+
+// Temperatures used to have a table of their own. They are copied into
+// measurements, in degrees Celsius, the first time the KB starts, and the
+// old table is kept as temperatures_old for an admin to drop.
+
+const mysql_temperaturesExist = `SELECT table_name FROM information_schema.tables WHERE table_schema=DATABASE() and table_name='temperatures'`
+
+var mysql_moveTemperatures = []string{
+	`INSERT IGNORE INTO measurements (uname, sensor, kind, unit, timestamp, value) SELECT uname, sensor, 'temperature', 'C', timestamp, value FROM temperatures`,
+	`RENAME TABLE temperatures TO temperatures_old`,
+}
+
 // auth functions
 
 const mysql_getHash = `SELECT hashval FROM auth WHERE uname=?`
 
-const mysql_addToken = `INSERT INTO tokens VALUES (?, ?, ?)`
+const mysql_addToken = `INSERT INTO tokens (uname, token, exp, session) VALUES (?, ?, ?, ?)`
+
+const mysql_addUser = `INSERT INTO auth (uname, hashval) VALUES (?, ?)`
+
+const mysql_getAccount = `SELECT uname, role, disabled FROM auth WHERE uname=?`
+
+const mysql_listAccounts = `SELECT uname, role, disabled FROM auth ORDER BY uname`
+
+const mysql_setHash = `UPDATE auth SET hashval=? WHERE uname=?`
+
+const mysql_setRole = `UPDATE auth SET role=? WHERE uname=?`
+
+const mysql_setDisabled = `UPDATE auth SET disabled=? WHERE uname=?`
+
+const mysql_deleteUserTokens = `DELETE FROM tokens WHERE uname=?`
+
+const mysql_deleteUserSessions = `DELETE FROM sessions WHERE uname=?`
+
+const mysql_deleteUserAPIKeys = `DELETE FROM apikeys WHERE uname=?`
+
+const mysql_deleteUserUploads = `DELETE FROM uploads WHERE uname=?`
+
+const mysql_deleteUserLoginFailures = `DELETE FROM login_failures WHERE kind='user' and subject=?`
+
+const mysql_deleteUserMeasurements = `DELETE FROM measurements WHERE uname=?`
 
-const mysql_addUser = `INSERT INTO auth VALUES (?, ?)`
+const mysql_deleteUserWeather = `DELETE FROM weather WHERE l_id IN (SELECT l_id FROM location WHERE uname=?)`
 
-const mysql_getUser = `SELECT uname, exp FROM tokens WHERE token = ?`
+// A user's sole households are those no one else is a member of.
+const mysql_detachSoleHouseholdLocations = `UPDATE location SET h_id=0 WHERE h_id IN (SELECT h_id FROM household_members WHERE uname=? AND h_id NOT IN (SELECT h_id FROM household_members WHERE uname<>?))`
+
+const mysql_deleteSoleHouseholdSensors = `DELETE FROM household_sensors WHERE h_id IN (SELECT h_id FROM household_members WHERE uname=? AND h_id NOT IN (SELECT h_id FROM household_members WHERE uname<>?))`
+
+const mysql_deleteSoleHouseholds = `DELETE FROM households WHERE h_id IN (SELECT h_id FROM household_members WHERE uname=? AND h_id NOT IN (SELECT h_id FROM household_members WHERE uname<>?))`
+
+const mysql_deleteUserHouseholdSensors = `DELETE FROM household_sensors WHERE uname=?`
+
+const mysql_deleteUserMemberships = `DELETE FROM household_members WHERE uname=?`
+
+const mysql_deleteUserCalibrations = `DELETE FROM calibrations WHERE uname=?`
+
+const mysql_deleteUserThermalFrames = `DELETE FROM thermal_frames WHERE uname=?`
+
+const mysql_deleteUserSensors = `DELETE FROM sensors WHERE uname=?`
+
+const mysql_deleteUserLocations = `DELETE FROM location WHERE uname=?`
+
+const mysql_deleteUser = `DELETE FROM auth WHERE uname=?`
+
+const mysql_getUser = `SELECT uname, exp, session FROM tokens WHERE token = ?`
 
 const mysql_expireToken = `UPDATE tokens SET exp=0 where token=?`
 
 const mysql_purgeTokens = `DELETE FROM tokens WHERE exp < ?`
 
+const mysql_purgeSessions = `DELETE FROM sessions WHERE exp < ?`
+
+const mysql_purgeRevoked = `DELETE FROM revoked WHERE exp < ?`
+
+const mysql_purgeUploads = `DELETE FROM uploads WHERE exp < ?`
+
+const mysql_addRevoked = `INSERT IGNORE INTO revoked VALUES (?, ?)`
+
+const mysql_getRevoked = `SELECT token_id, exp FROM revoked WHERE exp >= ?`
+
+// session functions
+
+const mysql_addSession = `INSERT INTO sessions VALUES (?, ?, ?, ?, ?, ?)`
+
+const mysql_touchSession = `UPDATE sessions SET exp=?, last_used=?, client_ip=? WHERE s_id=? and exp>0`
+
+const mysql_getSession = `SELECT s_id, uname, created, exp, last_used, client_ip FROM sessions WHERE s_id=?`
+
+const mysql_listSessions = `SELECT s_id, uname, created, exp, last_used, client_ip FROM sessions WHERE uname=? and exp>=? ORDER BY created`
+
+const mysql_expireSessionTokens = `UPDATE tokens SET exp=0 WHERE uname=? and session=?`
+
+const mysql_expireSession = `UPDATE sessions SET exp=0 WHERE uname=? and s_id=?`
+
+const mysql_expireUserTokens = `UPDATE tokens SET exp=0 WHERE uname=?`
+
+const mysql_expireUserSessions = `UPDATE sessions SET exp=0 WHERE uname=?`
+
+// audit functions
+
+const mysql_addAudit = `INSERT INTO audit (timestamp, uname, action, subject, client_ip, detail) VALUES (?, ?, ?, ?, ?, ?)`
+
+const mysql_getAudit = `SELECT timestamp, uname, action, subject, client_ip, detail FROM audit WHERE timestamp>=? and timestamp<=? ORDER BY a_id`
+
+const mysql_getUserAudit = `SELECT timestamp, uname, action, subject, client_ip, detail FROM audit WHERE (uname=? or subject=?) and timestamp>=? and timestamp<=? ORDER BY a_id`
+
+// login throttling functions
+
+const mysql_getLoginFailure = `SELECT kind, subject, failures, last_failure, locked_until FROM login_failures WHERE kind=? and subject=?`
+
+const mysql_listLoginFailures = `SELECT kind, subject, failures, last_failure, locked_until FROM login_failures ORDER BY kind, subject`
+
+const mysql_setLoginFailure = `REPLACE INTO login_failures VALUES (?, ?, ?, ?, ?)`
+
+const mysql_clearLoginFailure = `DELETE FROM login_failures WHERE kind=? and subject=?`
+
+// device key functions
+
+const mysql_addAPIKey = `INSERT INTO apikeys (uname, key_id, name, hash, sensors, created) VALUES (?, ?, ?, ?, ?, ?)`
+
+const mysql_getAPIKey = `SELECT key_id, uname, name, hash, sensors, created, revoked FROM apikeys WHERE key_id=?`
+
+const mysql_listAPIKeys = `SELECT key_id, uname, name, hash, sensors, created, revoked FROM apikeys WHERE uname=? ORDER BY created`
+
+const mysql_revokeAPIKey = `UPDATE apikeys SET revoked=? WHERE uname=? and key_id=? and revoked=0`
+
+// idempotent upload functions
+
+const mysql_addUpload = `INSERT INTO uploads (idem_key, token_hash, uname, exp, started, response) VALUES (?, ?, ?, ?, ?, '')`
+
+const mysql_getUpload = `SELECT idem_key, token_hash, uname, exp, started, status, new_token, response FROM uploads WHERE token_hash=? and idem_key=?`
+
+const mysql_takeOverUpload = `UPDATE uploads SET uname=?, exp=?, started=? WHERE token_hash=? and idem_key=? and status=0 and started=?`
+
+const mysql_finishUpload = `UPDATE uploads SET status=?, new_token=?, response=? WHERE token_hash=? and idem_key=? and started=?`
+
+const mysql_deleteUpload = `DELETE FROM uploads WHERE token_hash=? and idem_key=?`
+
 // data functions
 
-const mysql_addTemperature = `INSERT IGNORE INTO temperatures VALUES (?, ?, ?, ?)`
+const mysql_addMeasurement = `INSERT IGNORE INTO measurements (uname, sensor, kind, unit, timestamp, value) VALUES (?, ?, ?, ?, ?, ?)`
+
+const mysql_getMeasurements = `SELECT uname, sensor, kind, unit, timestamp, value FROM measurements t WHERE (sensor=? or ?='') and (kind=? or ?='') and timestamp>=? and timestamp<=? and (uname=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.uname=? and s.uname=t.uname and s.sensor=t.sensor)) ORDER BY sensor, kind, uname, unit, timestamp`
 
 const mysql_addWeather = `REPLACE INTO weather VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-const mysql_getTemperatures = `SELECT timestamp, value FROM temperatures WHERE uname=? and sensor=? and timestamp>=? and timestamp<=?`
+const mysql_getWeather = `SELECT timestamp, sun_up, temperature, apparent_temperature, cloud_cover, humidity, pressure, precib_probability FROM weather WHERE l_id=? and timestamp>=? and timestamp<=? ORDER BY timestamp`
+
+const mysql_getSensorTemperatures = `SELECT timestamp, value FROM measurements t WHERE uname=? and sensor=? and kind=? and timestamp>=? and timestamp<=? and (uname=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.uname=? and s.uname=t.uname and s.sensor=t.sensor))`
+
+const mysql_getTemperatureRefs = `SELECT DISTINCT uname, sensor FROM measurements t WHERE kind=? and timestamp>=? and timestamp<=? and (uname=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.uname=? and s.uname=t.uname and s.sensor=t.sensor)) ORDER BY sensor, uname`
+
+const mysql_getUserTemperatures = `SELECT sensor, timestamp, value FROM measurements WHERE uname=? and kind=?`
+
+// thermal frame functions
+
+const mysql_addThermalFrame = `INSERT INTO thermal_frames (uname, sensor, timestamp, width, height, blob_key, t_min, t_max, t_mean) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const mysql_getThermalFrame = `SELECT f_id, uname, sensor, timestamp, width, height, blob_key, t_min, t_max, t_mean FROM thermal_frames t WHERE f_id=? and (uname=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.uname=? and s.uname=t.uname and s.sensor=t.sensor))`
+
+const mysql_getThermalFrames = `SELECT f_id, uname, sensor, timestamp, width, height, blob_key, t_min, t_max, t_mean FROM thermal_frames t WHERE (sensor=? or ?='') and timestamp>=? and timestamp<=? and (uname=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.uname=? and s.uname=t.uname and s.sensor=t.sensor)) ORDER BY timestamp, sensor`
 
-const mysql_getTemperatureSensors = `SELECT DISTINCT sensor FROM temperatures WHERE uname=? and timestamp>=? and timestamp<=?`
+const mysql_deleteThermalFrame = `DELETE FROM thermal_frames WHERE uname=? and f_id=?`
+
+// sensor functions
+
+const mysql_addSensor = `INSERT INTO sensors (uname, sensor, display_name, room, surface, side, l_id, model, calibration_offset, installed, removed) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const mysql_getSensor = `SELECT uname, sensor, display_name, room, surface, side, l_id, model, calibration_offset, installed, removed FROM sensors WHERE uname=? and sensor=?`
+
+const mysql_listSensors = `SELECT uname, sensor, display_name, room, surface, side, l_id, model, calibration_offset, installed, removed FROM sensors s WHERE uname=? or EXISTS (SELECT 1 FROM household_sensors hs JOIN household_members m ON m.h_id=hs.h_id WHERE m.uname=? and hs.uname=s.uname and hs.sensor=s.sensor) ORDER BY sensor, uname`
+
+const mysql_updateSensor = `UPDATE sensors SET display_name=?, room=?, surface=?, side=?, l_id=?, model=?, calibration_offset=?, installed=?, removed=? WHERE uname=? and sensor=?`
+
+const mysql_deleteSensor = `DELETE FROM sensors WHERE uname=? and sensor=?`
+
+// calibration functions
+
+const mysql_addCalibration = `INSERT INTO calibrations (uname, sensor, effective, gain, cal_offset) VALUES (?, ?, ?, ?, ?)`
+
+const mysql_listCalibrations = `SELECT c_id, uname, sensor, effective, gain, cal_offset FROM calibrations WHERE uname=? and (sensor=? or ?='') ORDER BY sensor, effective`
+
+const mysql_deleteCalibration = `DELETE FROM calibrations WHERE uname=? and c_id=?`
 
 // location functions
 
-const mysql_getCoordinates = `SELECT l_id, lat, long FROM location`
-const mysql_addLocation = `INSERT INTO location VALUES (?, ?, ?, ?)`
+const mysql_getCoordinates = `SELECT l_id, lat, lon FROM location`
+const mysql_addLocation = `INSERT INTO location (uname, place_name, lat, lon) VALUES (?, ?, ?, ?)`
+
+const mysql_getLocation = `SELECT l_id, uname AS owner, h_id, place_name, lat, lon FROM location WHERE l_id=? and (uname=? or h_id IN (SELECT h_id FROM household_members WHERE uname=?))`
+
+const mysql_listLocations = `SELECT l_id, uname AS owner, h_id, place_name, lat, lon FROM location WHERE (uname=? or h_id IN (SELECT h_id FROM household_members WHERE uname=?)) ORDER BY l_id`
+
+const mysql_updateLocation = `UPDATE location SET place_name=?, lat=?, lon=? WHERE uname=? and l_id=?`
+
+const mysql_deleteLocationWeather = `DELETE FROM weather WHERE l_id IN (SELECT l_id FROM location WHERE uname=? and l_id=?)`
+
+const mysql_detachLocationSensors = `UPDATE sensors SET l_id=0 WHERE l_id IN (SELECT l_id FROM location WHERE uname=? and l_id=?)`
+
+const mysql_deleteLocation = `DELETE FROM location WHERE uname=? and l_id=?`
+
+// household functions
+
+const mysql_addHousehold = `INSERT INTO households (name, created) VALUES (?, ?)`
+
+const mysql_getHousehold = `SELECT h_id, name, created FROM households WHERE h_id=?`
+
+const mysql_listHouseholds = `SELECT h.h_id, h.name, h.created, m.permission FROM households h JOIN household_members m ON m.h_id=h.h_id WHERE m.uname=? ORDER BY h.h_id`
+
+const mysql_detachHouseholdLocations = `UPDATE location SET h_id=0 WHERE h_id=?`
+
+const mysql_deleteHouseholdSensors = `DELETE FROM household_sensors WHERE h_id=?`
+
+const mysql_deleteHouseholdMembers = `DELETE FROM household_members WHERE h_id=?`
+
+const mysql_deleteHousehold = `DELETE FROM households WHERE h_id=?`
+
+const mysql_getMembership = `SELECT permission FROM household_members WHERE h_id=? and uname=?`
+
+const mysql_setMember = `REPLACE INTO household_members VALUES (?, ?, ?)`
+
+const mysql_removeMemberSensors = `DELETE FROM household_sensors WHERE h_id=? and uname=?`
+
+const mysql_detachMemberLocations = `UPDATE location SET h_id=0 WHERE h_id=? and uname=?`
+
+const mysql_removeMember = `DELETE FROM household_members WHERE h_id=? and uname=?`
+
+const mysql_listMembers = `SELECT uname, permission FROM household_members WHERE h_id=? ORDER BY uname`
+
+const mysql_addHouseholdSensor = `INSERT IGNORE INTO household_sensors VALUES (?, ?, ?)`
+
+const mysql_removeHouseholdSensor = `DELETE FROM household_sensors WHERE h_id=? and uname=? and sensor=?`
+
+const mysql_listHouseholdSensors = `SELECT uname, sensor FROM household_sensors WHERE h_id=? ORDER BY uname, sensor`
+
+const mysql_setLocationHousehold = `UPDATE location SET h_id=? WHERE uname=? and l_id=?`
@@ -18,6 +18,7 @@ import (
 	"database/sql"
 	"log"
 	s "strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -38,7 +39,7 @@ func NewSQLiteKB(dbFile string, sqliteOpts map[string]string) KB {
 		dbFile = s.Join([]string{dbFile, allArgs}, "")
 	}
 
-	newKB := &sqliteKB{inbound: make(chan *query)}
+	newKB := &sqliteKB{inbound: make(chan *query), done: make(chan bool)}
 	go kbLoop(dbFile, newKB)
 	return newKB
 }
@@ -51,11 +52,17 @@ func kbLoop(dbFile string, kb *sqliteKB) {
 	defer db.Close()
 
 	initSqliteDB(db)
+	migrate(db, sqlite_migrations)
+	moveTable(db, sqlite_temperaturesExist, sqlite_moveTemperatures)
 
 	for {
 		select {
 		case q := <-kb.inbound:
-			if q.result != nil {
+			if q.batch != nil {
+				doTx(db, q)
+			} else if q.batchArgs != nil {
+				doBatch(db, q)
+			} else if q.result != nil {
 				doInsert(db, q)
 			} else if q.rows != nil {
 				doQuery(db, q)
@@ -84,7 +91,67 @@ func initSqliteDB(db *sql.DB) {
 		log.Fatal(err)
 	}
 
-	_, err = tx.Exec(sqlite_createTemperature)
+	_, err = tx.Exec(sqlite_createSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createRevoked)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createAPIKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createLoginFailures)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createUpload)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createHousehold)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createHouseholdMember)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createHouseholdSensor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createAudit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createMeasurement)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createThermalFrame)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createSensor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = tx.Exec(sqlite_createCalibration)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -93,6 +160,11 @@ func initSqliteDB(db *sql.DB) {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	_, err = tx.Exec(sqlite_createWeather)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func (k *sqliteKB) Stop() {
@@ -109,21 +181,25 @@ func (k *sqliteKB) GetHash(user string) ([]byte, bool) {
 	k.inbound <- q
 	rows := <-q.rows
 	if len(rows) == 1 {
-		return rows[0]["hash"].([]byte), true
+		return []byte(rowString(rows[0]["hash"])), true
 	} else {
 		return nil, false
 	}
 }
 
-func (k *sqliteKB) AddToken(user, token string, expiration int64) bool {
+func (k *sqliteKB) AddToken(user, token string, expiration int64, session string) bool {
 	q := &query{
 		queryString: sqlite_addToken,
-		arguments:   []interface{}{user, token, expiration},
+		arguments:   []interface{}{user, token, expiration, session},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
 	k.inbound <- q
-	_, err := (<-q.result).RowsAffected()
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+	_, err := res.RowsAffected()
 	if err != nil {
 		return false
 	}
@@ -151,7 +227,118 @@ func (k *sqliteKB) AddUser(user, hash string) bool {
 	return true
 }
 
-func (k *sqliteKB) GetUser(token string) (string, int64, bool) {
+func (k *sqliteKB) GetAccount(user string) (Account, bool) {
+	q := &query{
+		queryString: sqlite_getAccount,
+		arguments:   []interface{}{user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Account{}, false
+	}
+	return rowAccount(rows[0], "user"), true
+}
+
+func (k *sqliteKB) ListAccounts() []Account {
+	q := &query{
+		queryString: sqlite_listAccounts,
+		arguments:   nil,
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	accounts := make([]Account, 0, len(rows))
+	for _, row := range rows {
+		accounts = append(accounts, rowAccount(row, "user"))
+	}
+	return accounts
+}
+
+func (k *sqliteKB) SetHash(user, hash string) bool {
+	return k.updateUser(sqlite_setHash, hash, user)
+}
+
+func (k *sqliteKB) SetRole(user, role string) bool {
+	return k.updateUser(sqlite_setRole, role, user)
+}
+
+func (k *sqliteKB) SetDisabled(user string, disabled bool) bool {
+	return k.updateUser(sqlite_setDisabled, disabled, user)
+}
+
+// DeleteUser removes a user together with their tokens, device keys,
+// temperatures, locations and the weather of those locations, and the
+// households no one else is in, all in one transaction. Nothing is removed
+// if there is no such user.
+func (k *sqliteKB) DeleteUser(user string) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	for _, b := range []struct {
+		queryString string
+		arguments   []interface{}
+	}{
+		{sqlite_deleteUserTokens, []interface{}{user}},
+		{sqlite_deleteUserSessions, []interface{}{user}},
+		{sqlite_deleteUserAPIKeys, []interface{}{user}},
+		{sqlite_deleteUserUploads, []interface{}{user}},
+		{sqlite_deleteUserLoginFailures, []interface{}{user}},
+		{sqlite_deleteUserMeasurements, []interface{}{user}},
+		{sqlite_deleteUserWeather, []interface{}{user}},
+		{sqlite_detachSoleHouseholdLocations, []interface{}{user, user}},
+		{sqlite_deleteSoleHouseholdSensors, []interface{}{user, user}},
+		{sqlite_deleteSoleHouseholds, []interface{}{user, user}},
+		{sqlite_deleteUserHouseholdSensors, []interface{}{user}},
+		{sqlite_deleteUserMemberships, []interface{}{user}},
+		{sqlite_deleteUserCalibrations, []interface{}{user}},
+		{sqlite_deleteUserThermalFrames, []interface{}{user}},
+		{sqlite_deleteUserSensors, []interface{}{user}},
+		{sqlite_deleteUserLocations, []interface{}{user}},
+	} {
+		q.batch = append(q.batch, &query{queryString: b.queryString, arguments: b.arguments})
+	}
+	q.batch = append(q.batch, &query{queryString: sqlite_deleteUser, arguments: []interface{}{user}, mustAffect: true})
+	k.inbound <- q
+
+	_, ok := <-q.result
+	return ok
+}
+
+// updateUser runs a statement on the auth table and reports whether it
+// matched a row.
+func (k *sqliteKB) updateUser(queryString string, arguments ...interface{}) bool {
+	q := &query{
+		queryString: queryString,
+		arguments:   arguments,
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// GetUser returns the owner, expiration and session of a rotating token.
+func (k *sqliteKB) GetUser(token string) (string, int64, string, bool) {
 	q := &query{
 		queryString: sqlite_getUser,
 		arguments:   []interface{}{token},
@@ -161,10 +348,9 @@ func (k *sqliteKB) GetUser(token string) (string, int64, bool) {
 	k.inbound <- q
 	rows := <-q.rows
 	if len(rows) == 1 {
-		//		log.Printf("%T.%T\n", rows[0]["user"], rows[0]["exp"])
-		return string(rows[0]["user"].([]byte)), rows[0]["exp"].(int64), true
+		return rowString(rows[0]["user"]), rowInt(rows[0]["exp"]), rowString(rows[0]["session"]), true
 	} else {
-		return "", 0, false
+		return "", 0, "", false
 	}
 }
 
@@ -190,10 +376,36 @@ func (k *sqliteKB) ExpireToken(token string) bool {
 	return true
 }
 
+// PurgeTokens deletes rotating tokens, revocations and remembered uploads
+// that expired before expiration.
 func (k *sqliteKB) PurgeTokens(expiration int64) bool {
+	for _, queryString := range []string{sqlite_purgeTokens, sqlite_purgeSessions, sqlite_purgeRevoked, sqlite_purgeUploads} {
+		q := &query{
+			queryString: queryString,
+			arguments:   []interface{}{expiration},
+			rows:        nil,
+			result:      make(chan sql.Result),
+		}
+		k.inbound <- q
+
+		res, ok := <-q.result
+		if !ok {
+			return false
+		}
+
+		_, err := res.RowsAffected()
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (k *sqliteKB) AddRevoked(tokenID string, expiration int64) bool {
 	q := &query{
-		queryString: sqlite_purgeTokens,
-		arguments:   []interface{}{expiration},
+		queryString: sqlite_addRevoked,
+		arguments:   []interface{}{tokenID, expiration},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
@@ -208,14 +420,35 @@ func (k *sqliteKB) PurgeTokens(expiration int64) bool {
 	if err != nil {
 		return false
 	}
-
 	return true
 }
 
-func (k *sqliteKB) AddTemperature(user, sensor string, timestamp, value float64) bool {
+// GetRevoked returns the revoked signed token ids that have not expired by
+// now, mapped to their expiration.
+func (k *sqliteKB) GetRevoked(now int64) (map[string]int64, bool) {
+	q := &query{
+		queryString: sqlite_getRevoked,
+		arguments:   []interface{}{now},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil, false
+	}
+	out := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		out[rowString(row["token_id"])] = rowInt(row["exp"])
+	}
+	return out, true
+}
+
+func (k *sqliteKB) AddSession(session Session) bool {
 	q := &query{
-		queryString: sqlite_addTemperature,
-		arguments:   []interface{}{user, sensor, timestamp, value},
+		queryString: sqlite_addSession,
+		arguments:   []interface{}{session.ID, session.User, session.Created, session.Expires, session.LastUsed, session.ClientIP},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
@@ -230,14 +463,14 @@ func (k *sqliteKB) AddTemperature(user, sensor string, timestamp, value float64)
 	if err != nil {
 		return false
 	}
-
 	return true
 }
 
-func (k *sqliteKB) AddWeather(location, timestamp int64, sunUp bool, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability float64) bool {
+// TouchSession records a use of a session that has not been revoked.
+func (k *sqliteKB) TouchSession(id string, expiration, lastUsed int64, clientIP string) bool {
 	q := &query{
-		queryString: sqlite_addWeather,
-		arguments:   []interface{}{location, timestamp, sunUp, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability},
+		queryString: sqlite_touchSession,
+		arguments:   []interface{}{expiration, lastUsed, clientIP, id},
 		rows:        nil,
 		result:      make(chan sql.Result),
 	}
@@ -245,61 +478,151 @@ func (k *sqliteKB) AddWeather(location, timestamp int64, sunUp bool, temperature
 
 	res, ok := <-q.result
 	if !ok {
-		log.Printf("%v\n", q)
 		return false
 	}
 
-	_, err := res.RowsAffected()
-	if err != nil {
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
 		return false
 	}
-
 	return true
 }
 
-func (k *sqliteKB) GetTemperatures(user, sensor string, start, end float64) map[float64]float64 {
+func (k *sqliteKB) GetSession(id string) (Session, bool) {
+	q := &query{
+		queryString: sqlite_getSession,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Session{}, false
+	}
+	return rowSession(rows[0], "user"), true
+}
+
+// ListSessions returns the user's sessions that have not expired by now.
+func (k *sqliteKB) ListSessions(user string, now int64) []Session {
 	q := &query{
-		queryString: sqlite_getTemperatures,
-		arguments:   []interface{}{user, sensor, start, end},
+		queryString: sqlite_listSessions,
+		arguments:   []interface{}{user, now},
 		rows:        make(chan []map[string]interface{}),
 		result:      nil,
 	}
 	k.inbound <- q
 
 	rows, ok := <-q.rows
-	out = make(map[float64]float64)
 	if !ok {
-		return out
+		return nil
 	}
+	sessions := make([]Session, 0, len(rows))
 	for _, row := range rows {
-		out[row["timestamp"].(float64)] = row["value"].(float64)
+		sessions = append(sessions, rowSession(row, "user"))
 	}
-	return out
+	return sessions
+}
+
+// RevokeSession expires one of the user's sessions and its tokens, or all
+// of them when id is empty.
+func (k *sqliteKB) RevokeSession(user, id string) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	if id == "" {
+		q.batch = []*query{
+			{queryString: sqlite_expireUserTokens, arguments: []interface{}{user}},
+			{queryString: sqlite_expireUserSessions, arguments: []interface{}{user}},
+		}
+	} else {
+		q.batch = []*query{
+			{queryString: sqlite_expireSessionTokens, arguments: []interface{}{user, id}},
+			{queryString: sqlite_expireSession, arguments: []interface{}{user, id}},
+		}
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || (id != "" && n == 0) {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) AddAudit(event AuditEvent) bool {
+	q := &query{
+		queryString: sqlite_addAudit,
+		arguments:   []interface{}{event.Timestamp, event.User, event.Action, event.Subject, event.ClientIP, event.Detail},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
 }
 
-func (k *sqliteKB) GetTemperatureSensors(user string, start, end float64) []string {
+// GetAudit returns the events in a time range, oldest first. With a user,
+// only the events they caused or that were done to them.
+func (k *sqliteKB) GetAudit(user string, start, end int64) []AuditEvent {
 	q := &query{
-		queryString: sqlite_getTemperatureSensors,
-		arguments:   []interface{}{user, start, end},
+		queryString: sqlite_getAudit,
+		arguments:   []interface{}{start, end},
 		rows:        make(chan []map[string]interface{}),
 		result:      nil,
 	}
+	if user != "" {
+		q.queryString = sqlite_getUserAudit
+		q.arguments = []interface{}{user, user, start, end}
+	}
 	k.inbound <- q
 
 	rows, ok := <-q.rows
 	if !ok {
 		return nil
 	}
-	sens := make([]string, 0, len(rows))
+	events := make([]AuditEvent, 0, len(rows))
 	for _, row := range rows {
-		sens = append(sens, string(row["sensor"].([]byte)))
+		events = append(events, rowAuditEvent(row, "user"))
 	}
-	return sens
+	return events
 }
 
-func (k *sqliteKB) GetCoordinates() ([][]string, []int64, bool) {
+func (k *sqliteKB) GetLoginFailure(kind, subject string) (LoginFailure, bool) {
 	q := &query{
-		queryString: sqlite_getCoordinates,
+		queryString: sqlite_getLoginFailure,
+		arguments:   []interface{}{kind, subject},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return LoginFailure{Kind: kind, Subject: subject}, false
+	}
+	return rowLoginFailure(rows[0]), true
+}
+
+func (k *sqliteKB) ListLoginFailures() []LoginFailure {
+	q := &query{
+		queryString: sqlite_listLoginFailures,
 		arguments:   nil,
 		rows:        make(chan []map[string]interface{}),
 		result:      nil,
@@ -308,19 +631,1126 @@ func (k *sqliteKB) GetCoordinates() ([][]string, []int64, bool) {
 
 	rows, ok := <-q.rows
 	if !ok {
-		return nil, nil, false
-	}
-	if len(rows) == 0 {
-		return nil, nil, false
+		return nil
 	}
-	coords := make([][]string, 0, len(rows))
-	l_ids := make([]int64, 0, len(rows))
+	out := make([]LoginFailure, 0, len(rows))
 	for _, row := range rows {
-		pair := make([]string, 2)
-		pair[0] = string(row["lat"].([]byte))
-		pair[1] = string(row["long"].([]byte))
-		coords = append(coords, pair)
-		l_ids = append(l_ids, row["l_id"].(int64))
+		out = append(out, rowLoginFailure(row))
 	}
-	return coords, l_ids, true
+	return out
+}
+
+func (k *sqliteKB) SetLoginFailure(f LoginFailure) bool {
+	q := &query{
+		queryString: sqlite_setLoginFailure,
+		arguments:   []interface{}{f.Kind, f.Subject, f.Failures, f.LastFailure, f.LockedUntil},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// ClearLoginFailure forgets the failures of a subject; it reports false when
+// there were none.
+func (k *sqliteKB) ClearLoginFailure(kind, subject string) bool {
+	q := &query{
+		queryString: sqlite_clearLoginFailure,
+		arguments:   []interface{}{kind, subject},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) AddAPIKey(user string, key APIKey) bool {
+	q := &query{
+		queryString: sqlite_addAPIKey,
+		arguments:   []interface{}{user, key.ID, key.Name, key.Hash, encodeSensors(key.Sensors), key.Created},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) GetAPIKey(id string) (APIKey, bool) {
+	q := &query{
+		queryString: sqlite_getAPIKey,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return APIKey{}, false
+	}
+	return rowAPIKey(rows[0], "user"), true
+}
+
+func (k *sqliteKB) ListAPIKeys(user string) []APIKey {
+	q := &query{
+		queryString: sqlite_listAPIKeys,
+		arguments:   []interface{}{user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, rowAPIKey(row, "user"))
+	}
+	return keys
+}
+
+// RevokeAPIKey marks one of the user's keys revoked as of when; it reports
+// false when there was no such active key.
+func (k *sqliteKB) RevokeAPIKey(user, id string, when int64) bool {
+	q := &query{
+		queryString: sqlite_revokeAPIKey,
+		arguments:   []interface{}{when, user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// AddUpload claims an idempotency key for the upload about to be handled.
+// It fails if the key is already taken for the same token.
+func (k *sqliteKB) AddUpload(upload Upload) bool {
+	q := &query{
+		queryString: sqlite_addUpload,
+		arguments:   []interface{}{upload.Key, upload.TokenHash, upload.User, upload.Expires, upload.Started},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) GetUpload(tokenHash, key string) (Upload, bool) {
+	q := &query{
+		queryString: sqlite_getUpload,
+		arguments:   []interface{}{tokenHash, key},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Upload{}, false
+	}
+	return rowUpload(rows[0], "user"), true
+}
+
+// TakeOverUpload claims an idempotency key again for upload, if the attempt
+// that claimed it at started has not finished. It fails if another retry
+// took the key over first.
+func (k *sqliteKB) TakeOverUpload(upload Upload, started int64) bool {
+	q := &query{
+		queryString: sqlite_takeOverUpload,
+		arguments:   []interface{}{upload.User, upload.Expires, upload.Started, upload.TokenHash, upload.Key, started},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// FinishUpload stores the answer to an upload, unless its key has since
+// been taken over by a retry.
+func (k *sqliteKB) FinishUpload(upload Upload) bool {
+	q := &query{
+		queryString: sqlite_finishUpload,
+		arguments:   []interface{}{upload.Status, upload.NewToken, upload.Response, upload.TokenHash, upload.Key, upload.Started},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+// DeleteUpload frees an idempotency key, so the next upload with it is
+// handled afresh.
+func (k *sqliteKB) DeleteUpload(tokenHash, key string) bool {
+	q := &query{
+		queryString: sqlite_deleteUpload,
+		arguments:   []interface{}{tokenHash, key},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) AddTemperature(user, sensor string, timestamp, value float64) bool {
+	q := &query{
+		queryString: sqlite_addMeasurement,
+		arguments:   []interface{}{user, sensor, KindTemperature, kindUnits[KindTemperature], timestamp, value},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (k *sqliteKB) AddMeasurement(m Measurement) bool {
+	q := &query{
+		queryString: sqlite_addMeasurement,
+		arguments:   []interface{}{m.Owner, m.Sensor, m.Kind, m.Unit, m.Timestamp, m.Value},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// AddMeasurements stores the measurements in one transaction and returns
+// how many of them were new. Those already stored are left as they are.
+func (k *sqliteKB) AddMeasurements(ms []Measurement) (int64, bool) {
+	q := &query{
+		queryString: sqlite_addMeasurement,
+		rows:        nil,
+		result:      make(chan sql.Result),
+		batchArgs:   make([][]interface{}, 0, len(ms)),
+	}
+	for _, m := range ms {
+		q.batchArgs = append(q.batchArgs, []interface{}{m.Owner, m.Sensor, m.Kind, m.Unit, m.Timestamp, m.Value})
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// GetMeasurements returns the measurements of the user's sensors and of
+// those shared with their households in the range. An empty sensor or kind
+// matches all of them.
+func (k *sqliteKB) GetMeasurements(user, sensor, kind string, start, end float64) []Measurement {
+	q := &query{
+		queryString: sqlite_getMeasurements,
+		arguments:   []interface{}{sensor, sensor, kind, kind, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	ms := make([]Measurement, 0, len(rows))
+	for _, row := range rows {
+		ms = append(ms, rowMeasurement(row, "user"))
+	}
+	return ms
+}
+
+// AddTemperatures stores the readings in one transaction and returns how
+// many of them were new.
+func (k *sqliteKB) AddTemperatures(user, sensor string, readings []Reading) (int64, bool) {
+	q := &query{
+		queryString: sqlite_addMeasurement,
+		rows:        nil,
+		result:      make(chan sql.Result),
+		batchArgs:   make([][]interface{}, 0, len(readings)),
+	}
+	for _, r := range readings {
+		q.batchArgs = append(q.batchArgs, []interface{}{user, sensor, KindTemperature, kindUnits[KindTemperature], r.Timestamp, r.Value})
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func (k *sqliteKB) AddWeather(location, timestamp int64, sunUp bool, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability float64) bool {
+	q := &query{
+		queryString: sqlite_addWeather,
+		arguments:   []interface{}{location, timestamp, sunUp, temperature, apparentTemperature, cloudCover, humidity, pressure, precipProbability},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		log.Printf("%v\n", q)
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (k *sqliteKB) GetWeather(location int64, start, end float64) []Weather {
+	q := &query{
+		queryString: sqlite_getWeather,
+		arguments:   []interface{}{location, start, end},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	out := make([]Weather, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, rowWeather(row))
+	}
+	return out
+}
+
+// GetSensorTemperatures returns the readings of a sensor in the range, if
+// the user owns it or its owner shares it with one of their households.
+func (k *sqliteKB) GetSensorTemperatures(user string, ref SensorRef, start, end float64) map[float64]float64 {
+	q := &query{
+		queryString: sqlite_getSensorTemperatures,
+		arguments:   []interface{}{ref.Owner, ref.Sensor, KindTemperature, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	out := make(map[float64]float64)
+	if !ok {
+		return out
+	}
+	for _, row := range rows {
+		out[rowFloat(row["timestamp"])] = rowFloat(row["value"])
+	}
+	return out
+}
+
+// GetTemperatureRefs lists the user's own sensors and those shared with
+// their households that have readings in the range, ordered by name. Two
+// people's sensors may share a name, so each comes with its owner.
+func (k *sqliteKB) GetTemperatureRefs(user string, start, end float64) []SensorRef {
+	q := &query{
+		queryString: sqlite_getTemperatureRefs,
+		arguments:   []interface{}{KindTemperature, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	refs := make([]SensorRef, 0, len(rows))
+	for _, row := range rows {
+		refs = append(refs, SensorRef{Owner: rowString(row["user"]), Sensor: rowString(row["sensor"])})
+	}
+	return refs
+}
+
+func (k *sqliteKB) GetAlignedSeries(user string, location int64, sensors []string, start, end, interval float64, fill Fill) (*AlignedSeries, bool) {
+	return alignSeries(k, user, location, sensors, start, end, interval, fill)
+}
+
+func (k *sqliteKB) AddThermalFrame(frame ThermalFrame) (int64, bool) {
+	q := &query{
+		queryString: sqlite_addThermalFrame,
+		arguments:   []interface{}{frame.Owner, frame.Sensor, frame.Timestamp, frame.Width, frame.Height, frame.Blob, frame.Min, frame.Max, frame.Mean},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// GetThermalFrame returns a frame of the user's or of a sensor shared with
+// their households.
+func (k *sqliteKB) GetThermalFrame(user string, id int64) (ThermalFrame, bool) {
+	q := &query{
+		queryString: sqlite_getThermalFrame,
+		arguments:   []interface{}{id, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return ThermalFrame{}, false
+	}
+	return rowThermalFrame(rows[0], "user"), true
+}
+
+// GetThermalFrames lists the frames taken in the range by sensor, or by all
+// of the user's sensors and those shared with them when sensor is empty.
+func (k *sqliteKB) GetThermalFrames(user, sensor string, start, end float64) []ThermalFrame {
+	q := &query{
+		queryString: sqlite_getThermalFrames,
+		arguments:   []interface{}{sensor, sensor, start, end, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	frames := make([]ThermalFrame, 0, len(rows))
+	for _, row := range rows {
+		frames = append(frames, rowThermalFrame(row, "user"))
+	}
+	return frames
+}
+
+func (k *sqliteKB) DeleteThermalFrame(user string, id int64) bool {
+	q := &query{
+		queryString: sqlite_deleteThermalFrame,
+		arguments:   []interface{}{user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) AddSensor(sensor Sensor) bool {
+	q := &query{
+		queryString: sqlite_addSensor,
+		arguments:   []interface{}{sensor.Owner, sensor.Name, sensor.DisplayName, sensor.Room, sensor.Surface, sensor.Side, sensor.Location, sensor.Model, sensor.CalibrationOffset, sensor.Installed, sensor.Removed},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// GetSensor returns the metadata of one of the user's own sensors.
+func (k *sqliteKB) GetSensor(user, sensor string) (Sensor, bool) {
+	q := &query{
+		queryString: sqlite_getSensor,
+		arguments:   []interface{}{user, sensor},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Sensor{}, false
+	}
+	return rowSensor(rows[0], "user"), true
+}
+
+// ListSensors returns the metadata of the user's sensors and of those shared
+// with their households.
+func (k *sqliteKB) ListSensors(user string) []Sensor {
+	q := &query{
+		queryString: sqlite_listSensors,
+		arguments:   []interface{}{user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	sensors := make([]Sensor, 0, len(rows))
+	for _, row := range rows {
+		sensors = append(sensors, rowSensor(row, "user"))
+	}
+	return sensors
+}
+
+func (k *sqliteKB) UpdateSensor(sensor Sensor) bool {
+	q := &query{
+		queryString: sqlite_updateSensor,
+		arguments:   []interface{}{sensor.DisplayName, sensor.Room, sensor.Surface, sensor.Side, sensor.Location, sensor.Model, sensor.CalibrationOffset, sensor.Installed, sensor.Removed, sensor.Owner, sensor.Name},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// DeleteSensor removes a sensor's metadata. Its readings are kept.
+func (k *sqliteKB) DeleteSensor(user, sensor string) bool {
+	q := &query{
+		queryString: sqlite_deleteSensor,
+		arguments:   []interface{}{user, sensor},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) AddCalibration(cal Calibration) (int64, bool) {
+	q := &query{
+		queryString: sqlite_addCalibration,
+		arguments:   []interface{}{cal.Owner, cal.Sensor, cal.Effective, cal.Gain, cal.Offset},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// ListCalibrations returns the user's calibrations for sensor, or for all
+// their sensors when sensor is empty, oldest first.
+func (k *sqliteKB) ListCalibrations(user, sensor string) []Calibration {
+	q := &query{
+		queryString: sqlite_listCalibrations,
+		arguments:   []interface{}{user, sensor, sensor},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	cals := make([]Calibration, 0, len(rows))
+	for _, row := range rows {
+		cals = append(cals, rowCalibration(row, "user"))
+	}
+	return cals
+}
+
+func (k *sqliteKB) DeleteCalibration(user string, id int64) bool {
+	q := &query{
+		queryString: sqlite_deleteCalibration,
+		arguments:   []interface{}{user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) GetCoordinates() ([][]string, []int64, bool) {
+	q := &query{
+		queryString: sqlite_getCoordinates,
+		arguments:   nil,
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil, nil, false
+	}
+	if len(rows) == 0 {
+		return nil, nil, false
+	}
+	coords := make([][]string, 0, len(rows))
+	l_ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		pair := make([]string, 2)
+		pair[0] = rowString(row["lat"])
+		pair[1] = rowString(row["lon"])
+		coords = append(coords, pair)
+		l_ids = append(l_ids, rowInt(row["l_id"]))
+	}
+	return coords, l_ids, true
+}
+
+func (k *sqliteKB) AddLocation(user, placeName, lat, lon string) (int64, bool) {
+	q := &query{
+		queryString: sqlite_addLocation,
+		arguments:   []interface{}{user, placeName, lat, lon},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func (k *sqliteKB) GetLocation(user string, id int64) (Location, bool) {
+	q := &query{
+		queryString: sqlite_getLocation,
+		arguments:   []interface{}{id, user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Location{}, false
+	}
+	return rowLocation(rows[0]), true
+}
+
+func (k *sqliteKB) ListLocations(user string) []Location {
+	q := &query{
+		queryString: sqlite_listLocations,
+		arguments:   []interface{}{user, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	locs := make([]Location, 0, len(rows))
+	for _, row := range rows {
+		locs = append(locs, rowLocation(row))
+	}
+	return locs
+}
+
+func (k *sqliteKB) UpdateLocation(user string, id int64, placeName, lat, lon string) bool {
+	q := &query{
+		queryString: sqlite_updateLocation,
+		arguments:   []interface{}{placeName, lat, lon, user, id},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// DeleteLocation removes the location and the weather collected for it.
+func (k *sqliteKB) DeleteLocation(user string, id int64) bool {
+	for _, queryString := range []string{sqlite_deleteLocationWeather, sqlite_detachLocationSensors, sqlite_deleteLocation} {
+		q := &query{
+			queryString: queryString,
+			arguments:   []interface{}{user, id},
+			rows:        nil,
+			result:      make(chan sql.Result),
+		}
+		k.inbound <- q
+
+		res, ok := <-q.result
+		if !ok {
+			return false
+		}
+
+		_, err := res.RowsAffected()
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *sqliteKB) GetUserTemperatures(user string) map[string]map[float64]float64 {
+	q := &query{
+		queryString: sqlite_getUserTemperatures,
+		arguments:   []interface{}{user, KindTemperature},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	out := make(map[string]map[float64]float64)
+	if !ok {
+		return out
+	}
+	for _, row := range rows {
+		sensor := rowString(row["sensor"])
+		if out[sensor] == nil {
+			out[sensor] = make(map[float64]float64)
+		}
+		out[sensor][rowFloat(row["timestamp"])] = rowFloat(row["value"])
+	}
+	return out
+}
+
+// AddHousehold creates a household with the user as its first admin.
+func (k *sqliteKB) AddHousehold(user, name string) (int64, bool) {
+	q := &query{
+		queryString: sqlite_addHousehold,
+		arguments:   []interface{}{name, time.Now().Unix()},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return 0, false
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false
+	}
+	return id, k.SetMember(id, user, HouseholdAdmin)
+}
+
+func (k *sqliteKB) GetHousehold(id int64) (Household, bool) {
+	q := &query{
+		queryString: sqlite_getHousehold,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return Household{}, false
+	}
+	return rowHousehold(rows[0]), true
+}
+
+func (k *sqliteKB) ListHouseholds(user string) []Household {
+	q := &query{
+		queryString: sqlite_listHouseholds,
+		arguments:   []interface{}{user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	households := make([]Household, 0, len(rows))
+	for _, row := range rows {
+		households = append(households, rowHousehold(row))
+	}
+	return households
+}
+
+// DeleteHousehold removes a household and its memberships. Its locations
+// and sensors go back to being private to the users who own them.
+func (k *sqliteKB) DeleteHousehold(id int64) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	for _, b := range []struct {
+		queryString string
+		arguments   []interface{}
+	}{
+		{sqlite_detachHouseholdLocations, []interface{}{id}},
+		{sqlite_deleteHouseholdSensors, []interface{}{id}},
+		{sqlite_deleteHouseholdMembers, []interface{}{id}},
+		{sqlite_deleteHousehold, []interface{}{id}},
+	} {
+		q.batch = append(q.batch, &query{queryString: b.queryString, arguments: b.arguments})
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) GetMembership(id int64, user string) (string, bool) {
+	q := &query{
+		queryString: sqlite_getMembership,
+		arguments:   []interface{}{id, user},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok || len(rows) != 1 {
+		return "", false
+	}
+	return rowString(rows[0]["permission"]), true
+}
+
+func (k *sqliteKB) SetMember(id int64, user, permission string) bool {
+	q := &query{
+		queryString: sqlite_setMember,
+		arguments:   []interface{}{id, user, permission},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// RemoveMember takes a user out of a household along with the sensors and
+// locations they shared with it.
+func (k *sqliteKB) RemoveMember(id int64, user string) bool {
+	q := &query{
+		rows:   nil,
+		result: make(chan sql.Result),
+	}
+	for _, b := range []struct {
+		queryString string
+		arguments   []interface{}
+	}{
+		{sqlite_removeMemberSensors, []interface{}{id, user}},
+		{sqlite_detachMemberLocations, []interface{}{id, user}},
+		{sqlite_removeMember, []interface{}{id, user}},
+	} {
+		q.batch = append(q.batch, &query{queryString: b.queryString, arguments: b.arguments})
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) ListMembers(id int64) []HouseholdMember {
+	q := &query{
+		queryString: sqlite_listMembers,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	members := make([]HouseholdMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, HouseholdMember{
+			User:       rowString(row["user"]),
+			Permission: rowString(row["permission"]),
+		})
+	}
+	return members
+}
+
+func (k *sqliteKB) AddHouseholdSensor(id int64, user, sensor string) bool {
+	q := &query{
+		queryString: sqlite_addHouseholdSensor,
+		arguments:   []interface{}{id, user, sensor},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	_, err := res.RowsAffected()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) RemoveHouseholdSensor(id int64, user, sensor string) bool {
+	q := &query{
+		queryString: sqlite_removeHouseholdSensor,
+		arguments:   []interface{}{id, user, sensor},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
+}
+
+func (k *sqliteKB) ListHouseholdSensors(id int64) []HouseholdSensor {
+	q := &query{
+		queryString: sqlite_listHouseholdSensors,
+		arguments:   []interface{}{id},
+		rows:        make(chan []map[string]interface{}),
+		result:      nil,
+	}
+	k.inbound <- q
+
+	rows, ok := <-q.rows
+	if !ok {
+		return nil
+	}
+	sensors := make([]HouseholdSensor, 0, len(rows))
+	for _, row := range rows {
+		sensors = append(sensors, HouseholdSensor{
+			User:   rowString(row["user"]),
+			Sensor: rowString(row["sensor"]),
+		})
+	}
+	return sensors
+}
+
+// SetLocationHousehold shares one of the user's locations with a household,
+// or makes it private again when household is 0.
+func (k *sqliteKB) SetLocationHousehold(user string, location, household int64) bool {
+	q := &query{
+		queryString: sqlite_setLocationHousehold,
+		arguments:   []interface{}{household, user, location},
+		rows:        nil,
+		result:      make(chan sql.Result),
+	}
+	k.inbound <- q
+
+	res, ok := <-q.result
+	if !ok {
+		return false
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false
+	}
+	return true
 }
@@ -27,21 +27,106 @@ const sqlite_createToken = `CREATE TABLE IF NOT EXISTS tokens (
 	exp INTEGER NOT NULL
 )`
 
-const sqlite_createTemperature = `CREATE TABLE IF NOT EXISTS temperatures(
+const sqlite_createSession = `CREATE TABLE IF NOT EXISTS sessions (
+	s_id TEXT PRIMARY KEY,
+	user TEXT REFERENCES auth (user),
+	created INTEGER NOT NULL,
+	exp INTEGER NOT NULL,
+	last_used INTEGER NOT NULL,
+	client_ip TEXT NOT NULL
+)`
+
+const sqlite_createRevoked = `CREATE TABLE IF NOT EXISTS revoked (
+	token_id TEXT PRIMARY KEY,
+	exp INTEGER NOT NULL
+)`
+
+const sqlite_createAPIKey = `CREATE TABLE IF NOT EXISTS apikeys (
+	key_id TEXT PRIMARY KEY,
+	user TEXT REFERENCES auth (user),
+	name TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	sensors TEXT NOT NULL,
+	created INTEGER NOT NULL,
+	revoked INTEGER NOT NULL DEFAULT 0
+)`
+
+const sqlite_createUpload = `CREATE TABLE IF NOT EXISTS uploads (
+	idem_key TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	user TEXT REFERENCES auth (user),
+	exp INTEGER NOT NULL,
+	started INTEGER NOT NULL DEFAULT 0,
+	status INTEGER NOT NULL DEFAULT 0,
+	new_token TEXT NOT NULL DEFAULT '',
+	response TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (token_hash, idem_key)
+)`
+
+const sqlite_createLoginFailures = `CREATE TABLE IF NOT EXISTS login_failures (
+	kind TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	failures INTEGER NOT NULL,
+	last_failure INTEGER NOT NULL,
+	locked_until INTEGER NOT NULL,
+	PRIMARY KEY (kind, subject)
+)`
+
+const sqlite_createMeasurement = `CREATE TABLE IF NOT EXISTS measurements(
 	user TEXT REFERENCES auth (user),
 	sensor TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	unit TEXT NOT NULL,
 	timestamp NUMERIC NOT NULL,
 	value NUMERIC NOT NULL,
-	PRIMARY KEY (user,sensor,timestamp)
+	PRIMARY KEY (user,sensor,kind,timestamp)
+)`
+
+const sqlite_createThermalFrame = `CREATE TABLE IF NOT EXISTS thermal_frames(
+	f_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user TEXT REFERENCES auth (user),
+	sensor TEXT NOT NULL,
+	timestamp NUMERIC NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	blob_key TEXT NOT NULL,
+	t_min NUMERIC NOT NULL,
+	t_max NUMERIC NOT NULL,
+	t_mean NUMERIC NOT NULL,
+	UNIQUE (user, sensor, timestamp)
+)`
+
+const sqlite_createSensor = `CREATE TABLE IF NOT EXISTS sensors(
+	user TEXT REFERENCES auth (user),
+	sensor TEXT NOT NULL,
+	display_name TEXT NOT NULL,
+	room TEXT NOT NULL,
+	surface TEXT NOT NULL,
+	side TEXT NOT NULL,
+	l_id INTEGER NOT NULL,
+	model TEXT NOT NULL,
+	calibration_offset NUMERIC NOT NULL,
+	installed INTEGER NOT NULL,
+	removed INTEGER NOT NULL,
+	PRIMARY KEY (user, sensor)
+)`
+
+const sqlite_createCalibration = `CREATE TABLE IF NOT EXISTS calibrations(
+	c_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user TEXT REFERENCES auth (user),
+	sensor TEXT NOT NULL,
+	effective NUMERIC NOT NULL,
+	gain NUMERIC NOT NULL,
+	cal_offset NUMERIC NOT NULL,
+	UNIQUE (user, sensor, effective)
 )`
 
 const sqlite_createLocation = `CREATE TABLE IF NOT EXISTS location(
-	l_id INTEGER AUTOINCREMENT,
+	l_id INTEGER PRIMARY KEY AUTOINCREMENT,
 	user TEXT REFERENCES auth (user),
 	place_name TEXT NOT NULL,
 	lat TEXT NOT NULL,
 	lon TEXT NOT NULL,
-	PRIMARY KEY (l_id),
 	UNIQUE (user, place_name)
 )`
 
@@ -58,31 +143,278 @@ const sqlite_createWeather = `CREATE TABLE IF NOT EXISTS weather(
 	PRIMARY KEY          (l_id, timestamp)
 )`
 
+const sqlite_createHousehold = `CREATE TABLE IF NOT EXISTS households(
+	h_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	created INTEGER NOT NULL
+)`
+
+const sqlite_createHouseholdMember = `CREATE TABLE IF NOT EXISTS household_members(
+	h_id INTEGER REFERENCES households (h_id),
+	user TEXT REFERENCES auth (user),
+	permission TEXT NOT NULL,
+	PRIMARY KEY (h_id, user)
+)`
+
+const sqlite_createHouseholdSensor = `CREATE TABLE IF NOT EXISTS household_sensors(
+	h_id INTEGER REFERENCES households (h_id),
+	user TEXT REFERENCES auth (user),
+	sensor TEXT NOT NULL,
+	PRIMARY KEY (h_id, user, sensor)
+)`
+
+const sqlite_createAudit = `CREATE TABLE IF NOT EXISTS audit(
+	a_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp INTEGER NOT NULL,
+	user TEXT NOT NULL,
+	action TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	client_ip TEXT NOT NULL,
+	detail TEXT NOT NULL
+)`
+
+// Columns added to existing tables, see migrate
+
+var sqlite_migrations = []string{
+	`ALTER TABLE auth ADD COLUMN role TEXT NOT NULL DEFAULT 'user'`,
+	`ALTER TABLE auth ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE location ADD COLUMN h_id INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tokens ADD COLUMN session TEXT NOT NULL DEFAULT ''`,
+}
+
+// Temperatures used to have a table of their own. They are copied into
+// measurements, in degrees Celsius, the first time the KB starts, and the
+// old table is kept as temperatures_old for an admin to drop.
+
+const sqlite_temperaturesExist = `SELECT name FROM sqlite_master WHERE type='table' and name='temperatures'`
+
+var sqlite_moveTemperatures = []string{
+	`INSERT OR IGNORE INTO measurements (user, sensor, kind, unit, timestamp, value) SELECT user, sensor, 'temperature', 'C', timestamp, value FROM temperatures`,
+	`ALTER TABLE temperatures RENAME TO temperatures_old`,
+}
+
 // auth functions
 
 const sqlite_getHash = `SELECT hash FROM auth WHERE user=?`
 
-const sqlite_addToken = `INSERT INTO tokens VALUES (?, ?, ?)`
+const sqlite_addToken = `INSERT INTO tokens (user, token, exp, session) VALUES (?, ?, ?, ?)`
+
+const sqlite_addUser = `INSERT INTO auth (user, hash) VALUES (?, ?)`
+
+const sqlite_getAccount = `SELECT user, role, disabled FROM auth WHERE user=?`
+
+const sqlite_listAccounts = `SELECT user, role, disabled FROM auth ORDER BY user`
+
+const sqlite_setHash = `UPDATE auth SET hash=? WHERE user=?`
+
+const sqlite_setRole = `UPDATE auth SET role=? WHERE user=?`
+
+const sqlite_setDisabled = `UPDATE auth SET disabled=? WHERE user=?`
+
+const sqlite_deleteUserTokens = `DELETE FROM tokens WHERE user=?`
+
+const sqlite_deleteUserSessions = `DELETE FROM sessions WHERE user=?`
+
+const sqlite_deleteUserAPIKeys = `DELETE FROM apikeys WHERE user=?`
+
+const sqlite_deleteUserUploads = `DELETE FROM uploads WHERE user=?`
+
+const sqlite_deleteUserLoginFailures = `DELETE FROM login_failures WHERE kind='user' and subject=?`
 
-const sqlite_addUser = `INSERT INTO auth VALUES (?, ?)`
+const sqlite_deleteUserMeasurements = `DELETE FROM measurements WHERE user=?`
 
-const sqlite_getUser = `SELECT user, exp FROM tokens WHERE token = ?`
+const sqlite_deleteUserWeather = `DELETE FROM weather WHERE l_id IN (SELECT l_id FROM location WHERE user=?)`
+
+// A user's sole households are those no one else is a member of.
+const sqlite_detachSoleHouseholdLocations = `UPDATE location SET h_id=0 WHERE h_id IN (SELECT h_id FROM household_members WHERE user=? AND h_id NOT IN (SELECT h_id FROM household_members WHERE user<>?))`
+
+const sqlite_deleteSoleHouseholdSensors = `DELETE FROM household_sensors WHERE h_id IN (SELECT h_id FROM household_members WHERE user=? AND h_id NOT IN (SELECT h_id FROM household_members WHERE user<>?))`
+
+const sqlite_deleteSoleHouseholds = `DELETE FROM households WHERE h_id IN (SELECT h_id FROM household_members WHERE user=? AND h_id NOT IN (SELECT h_id FROM household_members WHERE user<>?))`
+
+const sqlite_deleteUserHouseholdSensors = `DELETE FROM household_sensors WHERE user=?`
+
+const sqlite_deleteUserMemberships = `DELETE FROM household_members WHERE user=?`
+
+const sqlite_deleteUserCalibrations = `DELETE FROM calibrations WHERE user=?`
+
+const sqlite_deleteUserThermalFrames = `DELETE FROM thermal_frames WHERE user=?`
+
+const sqlite_deleteUserSensors = `DELETE FROM sensors WHERE user=?`
+
+const sqlite_deleteUserLocations = `DELETE FROM location WHERE user=?`
+
+const sqlite_deleteUser = `DELETE FROM auth WHERE user=?`
+
+const sqlite_getUser = `SELECT user, exp, session FROM tokens WHERE token = ?`
 
 const sqlite_expireToken = `UPDATE tokens SET exp=0 where token=?`
 
 const sqlite_purgeTokens = `DELETE FROM tokens WHERE exp < ?`
 
+const sqlite_purgeSessions = `DELETE FROM sessions WHERE exp < ?`
+
+const sqlite_purgeRevoked = `DELETE FROM revoked WHERE exp < ?`
+
+const sqlite_purgeUploads = `DELETE FROM uploads WHERE exp < ?`
+
+const sqlite_addRevoked = `INSERT OR IGNORE INTO revoked VALUES (?, ?)`
+
+const sqlite_getRevoked = `SELECT token_id, exp FROM revoked WHERE exp >= ?`
+
+// session functions
+
+const sqlite_addSession = `INSERT INTO sessions VALUES (?, ?, ?, ?, ?, ?)`
+
+const sqlite_touchSession = `UPDATE sessions SET exp=?, last_used=?, client_ip=? WHERE s_id=? and exp>0`
+
+const sqlite_getSession = `SELECT s_id, user, created, exp, last_used, client_ip FROM sessions WHERE s_id=?`
+
+const sqlite_listSessions = `SELECT s_id, user, created, exp, last_used, client_ip FROM sessions WHERE user=? and exp>=? ORDER BY created`
+
+const sqlite_expireSessionTokens = `UPDATE tokens SET exp=0 WHERE user=? and session=?`
+
+const sqlite_expireSession = `UPDATE sessions SET exp=0 WHERE user=? and s_id=?`
+
+const sqlite_expireUserTokens = `UPDATE tokens SET exp=0 WHERE user=?`
+
+const sqlite_expireUserSessions = `UPDATE sessions SET exp=0 WHERE user=?`
+
+// audit functions
+
+const sqlite_addAudit = `INSERT INTO audit (timestamp, user, action, subject, client_ip, detail) VALUES (?, ?, ?, ?, ?, ?)`
+
+const sqlite_getAudit = `SELECT timestamp, user, action, subject, client_ip, detail FROM audit WHERE timestamp>=? and timestamp<=? ORDER BY a_id`
+
+const sqlite_getUserAudit = `SELECT timestamp, user, action, subject, client_ip, detail FROM audit WHERE (user=? or subject=?) and timestamp>=? and timestamp<=? ORDER BY a_id`
+
+// login throttling functions
+
+const sqlite_getLoginFailure = `SELECT kind, subject, failures, last_failure, locked_until FROM login_failures WHERE kind=? and subject=?`
+
+const sqlite_listLoginFailures = `SELECT kind, subject, failures, last_failure, locked_until FROM login_failures ORDER BY kind, subject`
+
+const sqlite_setLoginFailure = `REPLACE INTO login_failures VALUES (?, ?, ?, ?, ?)`
+
+const sqlite_clearLoginFailure = `DELETE FROM login_failures WHERE kind=? and subject=?`
+
+// device key functions
+
+const sqlite_addAPIKey = `INSERT INTO apikeys (user, key_id, name, hash, sensors, created) VALUES (?, ?, ?, ?, ?, ?)`
+
+const sqlite_getAPIKey = `SELECT key_id, user, name, hash, sensors, created, revoked FROM apikeys WHERE key_id=?`
+
+const sqlite_listAPIKeys = `SELECT key_id, user, name, hash, sensors, created, revoked FROM apikeys WHERE user=? ORDER BY created`
+
+const sqlite_revokeAPIKey = `UPDATE apikeys SET revoked=? WHERE user=? and key_id=? and revoked=0`
+
+// idempotent upload functions
+
+const sqlite_addUpload = `INSERT INTO uploads (idem_key, token_hash, user, exp, started) VALUES (?, ?, ?, ?, ?)`
+
+const sqlite_getUpload = `SELECT idem_key, token_hash, user, exp, started, status, new_token, response FROM uploads WHERE token_hash=? and idem_key=?`
+
+const sqlite_takeOverUpload = `UPDATE uploads SET user=?, exp=?, started=? WHERE token_hash=? and idem_key=? and status=0 and started=?`
+
+const sqlite_finishUpload = `UPDATE uploads SET status=?, new_token=?, response=? WHERE token_hash=? and idem_key=? and started=?`
+
+const sqlite_deleteUpload = `DELETE FROM uploads WHERE token_hash=? and idem_key=?`
+
 // data functions
 
-const sqlite_addTemperature = `INSERT OR IGNORE INTO temperatures VALUES (?, ?, ?, ?)`
+const sqlite_addMeasurement = `INSERT OR IGNORE INTO measurements (user, sensor, kind, unit, timestamp, value) VALUES (?, ?, ?, ?, ?, ?)`
+
+const sqlite_getMeasurements = `SELECT user, sensor, kind, unit, timestamp, value FROM measurements t WHERE (sensor=? or ?='') and (kind=? or ?='') and timestamp>=? and timestamp<=? and (user=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.user=? and s.user=t.user and s.sensor=t.sensor)) ORDER BY sensor, kind, user, unit, timestamp`
 
 const sqlite_addWeather = `REPLACE INTO weather VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-const sqlite_getTemperatures = `SELECT timestamp, value FROM temperatures WHERE user=? and sensor=? and timestamp>=? and timestamp<=?`
+const sqlite_getWeather = `SELECT timestamp, sun_up, temperature, apparent_temperature, cloud_cover, humidity, pressure, precib_probability FROM weather WHERE l_id=? and timestamp>=? and timestamp<=? ORDER BY timestamp`
+
+const sqlite_getSensorTemperatures = `SELECT timestamp, value FROM measurements t WHERE user=? and sensor=? and kind=? and timestamp>=? and timestamp<=? and (user=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.user=? and s.user=t.user and s.sensor=t.sensor))`
+
+const sqlite_getTemperatureRefs = `SELECT DISTINCT user, sensor FROM measurements t WHERE kind=? and timestamp>=? and timestamp<=? and (user=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.user=? and s.user=t.user and s.sensor=t.sensor)) ORDER BY sensor, user`
+
+const sqlite_getUserTemperatures = `SELECT sensor, timestamp, value FROM measurements WHERE user=? and kind=?`
+
+// thermal frame functions
+
+const sqlite_addThermalFrame = `INSERT INTO thermal_frames (user, sensor, timestamp, width, height, blob_key, t_min, t_max, t_mean) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const sqlite_getThermalFrame = `SELECT f_id, user, sensor, timestamp, width, height, blob_key, t_min, t_max, t_mean FROM thermal_frames t WHERE f_id=? and (user=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.user=? and s.user=t.user and s.sensor=t.sensor))`
 
-const sqlite_getTemperatureSensors = `SELECT DISTINCT sensor FROM temperatures WHERE user=? and timestamp>=? and timestamp<=?`
+const sqlite_getThermalFrames = `SELECT f_id, user, sensor, timestamp, width, height, blob_key, t_min, t_max, t_mean FROM thermal_frames t WHERE (sensor=? or ?='') and timestamp>=? and timestamp<=? and (user=? or EXISTS (SELECT 1 FROM household_sensors s JOIN household_members m ON m.h_id=s.h_id WHERE m.user=? and s.user=t.user and s.sensor=t.sensor)) ORDER BY timestamp, sensor`
+
+const sqlite_deleteThermalFrame = `DELETE FROM thermal_frames WHERE user=? and f_id=?`
+
+// sensor functions
+
+const sqlite_addSensor = `INSERT INTO sensors (user, sensor, display_name, room, surface, side, l_id, model, calibration_offset, installed, removed) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const sqlite_getSensor = `SELECT user, sensor, display_name, room, surface, side, l_id, model, calibration_offset, installed, removed FROM sensors WHERE user=? and sensor=?`
+
+const sqlite_listSensors = `SELECT user, sensor, display_name, room, surface, side, l_id, model, calibration_offset, installed, removed FROM sensors s WHERE user=? or EXISTS (SELECT 1 FROM household_sensors hs JOIN household_members m ON m.h_id=hs.h_id WHERE m.user=? and hs.user=s.user and hs.sensor=s.sensor) ORDER BY sensor, user`
+
+const sqlite_updateSensor = `UPDATE sensors SET display_name=?, room=?, surface=?, side=?, l_id=?, model=?, calibration_offset=?, installed=?, removed=? WHERE user=? and sensor=?`
+
+const sqlite_deleteSensor = `DELETE FROM sensors WHERE user=? and sensor=?`
+
+// calibration functions
+
+const sqlite_addCalibration = `INSERT INTO calibrations (user, sensor, effective, gain, cal_offset) VALUES (?, ?, ?, ?, ?)`
+
+const sqlite_listCalibrations = `SELECT c_id, user, sensor, effective, gain, cal_offset FROM calibrations WHERE user=? and (sensor=? or ?='') ORDER BY sensor, effective`
+
+const sqlite_deleteCalibration = `DELETE FROM calibrations WHERE user=? and c_id=?`
 
 // location functions
 
-const sqlite_getCoordinates = `SELECT l_id, lat, long FROM location`
-const sqlite_addLocation = `INSERT INTO location VALUES (?, ?, ?, ?)`
+const sqlite_getCoordinates = `SELECT l_id, lat, lon FROM location`
+const sqlite_addLocation = `INSERT INTO location (user, place_name, lat, lon) VALUES (?, ?, ?, ?)`
+
+const sqlite_getLocation = `SELECT l_id, user AS owner, h_id, place_name, lat, lon FROM location WHERE l_id=? and (user=? or h_id IN (SELECT h_id FROM household_members WHERE user=?))`
+
+const sqlite_listLocations = `SELECT l_id, user AS owner, h_id, place_name, lat, lon FROM location WHERE (user=? or h_id IN (SELECT h_id FROM household_members WHERE user=?)) ORDER BY l_id`
+
+const sqlite_updateLocation = `UPDATE location SET place_name=?, lat=?, lon=? WHERE user=? and l_id=?`
+
+const sqlite_deleteLocationWeather = `DELETE FROM weather WHERE l_id IN (SELECT l_id FROM location WHERE user=? and l_id=?)`
+
+const sqlite_detachLocationSensors = `UPDATE sensors SET l_id=0 WHERE l_id IN (SELECT l_id FROM location WHERE user=? and l_id=?)`
+
+const sqlite_deleteLocation = `DELETE FROM location WHERE user=? and l_id=?`
+
+// household functions
+
+const sqlite_addHousehold = `INSERT INTO households (name, created) VALUES (?, ?)`
+
+const sqlite_getHousehold = `SELECT h_id, name, created FROM households WHERE h_id=?`
+
+const sqlite_listHouseholds = `SELECT h.h_id, h.name, h.created, m.permission FROM households h JOIN household_members m ON m.h_id=h.h_id WHERE m.user=? ORDER BY h.h_id`
+
+const sqlite_detachHouseholdLocations = `UPDATE location SET h_id=0 WHERE h_id=?`
+
+const sqlite_deleteHouseholdSensors = `DELETE FROM household_sensors WHERE h_id=?`
+
+const sqlite_deleteHouseholdMembers = `DELETE FROM household_members WHERE h_id=?`
+
+const sqlite_deleteHousehold = `DELETE FROM households WHERE h_id=?`
+
+const sqlite_getMembership = `SELECT permission FROM household_members WHERE h_id=? and user=?`
+
+const sqlite_setMember = `REPLACE INTO household_members VALUES (?, ?, ?)`
+
+const sqlite_removeMemberSensors = `DELETE FROM household_sensors WHERE h_id=? and user=?`
+
+const sqlite_detachMemberLocations = `UPDATE location SET h_id=0 WHERE h_id=? and user=?`
+
+const sqlite_removeMember = `DELETE FROM household_members WHERE h_id=? and user=?`
+
+const sqlite_listMembers = `SELECT user, permission FROM household_members WHERE h_id=? ORDER BY user`
+
+const sqlite_addHouseholdSensor = `INSERT OR IGNORE INTO household_sensors VALUES (?, ?, ?)`
+
+const sqlite_removeHouseholdSensor = `DELETE FROM household_sensors WHERE h_id=? and user=? and sensor=?`
+
+const sqlite_listHouseholdSensors = `SELECT user, sensor FROM household_sensors WHERE h_id=? ORDER BY user, sensor`
+
+const sqlite_setLocationHousehold = `UPDATE location SET h_id=? WHERE user=? and l_id=?`